@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BuilderSnapshot is the current state of a single builder as returned by
+// the REST API.
+type BuilderSnapshot struct {
+	Builder string
+	Msgs    []Message
+	State   string
+	Error   Message
+	// Errors are the recent build errors, oldest first. Unlike Error they
+	// are kept when the builder goes idle.
+	Errors       []BuildErrorMessage
+	LastProgress *BuildStatusMessage
+	LastSeen     time.Time `json:",omitzero"`
+	Stale        bool
+	// Restored is set for builders restored from the state file until new
+	// messages confirm their state.
+	Restored bool
+}
+
+type snapshotRequest struct {
+	builder string
+	reply   chan []BuilderSnapshot
+}
+
+// snapshot asks the publisher loop for the state of all builders, or only
+// the named one when builder is not empty.
+func (b *BuildStatusPublisher) snapshot(ctx context.Context, builder string) ([]BuilderSnapshot, error) {
+	req := snapshotRequest{
+		builder: builder,
+		reply:   make(chan []BuilderSnapshot, 1),
+	}
+
+	select {
+	case b.snapshotCh <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case snapshot := <-req.reply:
+		return snapshot, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// buildSnapshot must only be called from the publisher loop.
+func (b *BuildStatusPublisher) buildSnapshot(builder string) []BuilderSnapshot {
+	snapshot := []BuilderSnapshot{}
+	for _, name := range b.builderNames() {
+		if builder != "" && name != builder {
+			continue
+		}
+		buildStatus := b.buildStatus[name]
+
+		s := BuilderSnapshot{
+			Builder: name,
+			Msgs:    append([]Message{}, buildStatus.msgs...),
+			Errors:  append([]BuildErrorMessage{}, buildStatus.errors...),
+		}
+		if buildStatus.state != nil {
+			s.State = buildStatus.state.State
+		}
+		if buildStatus.error != nil {
+			s.Error = *buildStatus.error
+		}
+		if buildStatus.progress != nil {
+			progress := *buildStatus.progress
+			s.LastProgress = &progress
+		}
+		s.LastSeen = buildStatus.lastSeen
+		s.Stale = buildStatus.stale != nil
+		s.Restored = buildStatus.restored != nil
+		snapshot = append(snapshot, s)
+	}
+
+	return snapshot
+}
+
+func (b *BuildStatusPublisher) buildersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := b.snapshot(r.Context(), "")
+		if err != nil {
+			http.Error(w, "publisher unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, snapshot)
+	}
+}
+
+func (b *BuildStatusPublisher) builderHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := b.snapshot(r.Context(), r.PathValue("name"))
+		if err != nil {
+			http.Error(w, "publisher unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if len(snapshot) == 0 {
+			http.Error(w, "unknown builder", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, snapshot[0])
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("failed to write json response")
+	}
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIListsBuilders(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	for _, msg := range []Message{
+		NewSystemMessage("mqtt-connected", "Connected to broker"),
+		MessageFromString("build/BuilderB", "pulling git"),
+		MessageFromString("build/BuilderA/state", "online"),
+		MessageFromString("build/BuilderA", "1/2 1/3 main/packageA 1.0.0-r0"),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"packageA","hostname":"BuilderA"}`),
+	} {
+		channels.msg <- msg
+		publisher.makeStep()
+	}
+
+	recorder := requestAPI(t, publisher, "/api/builders")
+	require.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("application/json", recorder.Header().Get("Content-Type"))
+
+	var builders []map[string]any
+	require.NoError(json.Unmarshal(recorder.Body.Bytes(), &builders))
+	require.Len(builders, 2)
+
+	assert.Equal("BuilderA", builders[0]["Builder"])
+	assert.Equal("online", builders[0]["State"])
+	assert.Equal("packageA", builders[0]["Error"].(map[string]any)["Pkgname"])
+	assert.Equal("main/packageA", builders[0]["LastProgress"].(map[string]any)["PackageName"])
+	assert.Len(builders[0]["Msgs"], 1)
+	assert.Len(builders[0]["Errors"], 1)
+
+	assert.Equal("BuilderB", builders[1]["Builder"])
+	assert.Equal("", builders[1]["State"])
+	assert.Nil(builders[1]["Error"])
+	assert.Nil(builders[1]["LastProgress"])
+	assert.Len(builders[1]["Msgs"], 1)
+	assert.Equal([]any{}, builders[1]["Errors"])
+}
+
+func TestAPIListsNoBuilders(t *testing.T) {
+	publisher, _, cancel := createPublisher(t)
+	defer cancel()
+
+	recorder := requestAPI(t, publisher, "/api/builders")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, "[]", recorder.Body.String())
+}
+
+func TestAPIReturnsSingleBuilder(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	channels.msg <- MessageFromString("build/BuilderA", "1/2 1/3 main/packageA 1.0.0-r0")
+	publisher.makeStep()
+	channels.msg <- MessageFromString("build/BuilderA", "uploading packages to main")
+	publisher.makeStep()
+
+	recorder := requestAPI(t, publisher, "/api/builders/BuilderA")
+	require.Equal(http.StatusOK, recorder.Code)
+
+	var builder map[string]any
+	require.NoError(json.Unmarshal(recorder.Body.Bytes(), &builder))
+
+	assert.Equal("BuilderA", builder["Builder"])
+	assert.Len(builder["Msgs"], 2)
+	progress := builder["LastProgress"].(map[string]any)
+	assert.Equal(map[string]any{"Current": 1.0, "Total": 2.0}, progress["BuildProgress"])
+	assert.Equal(map[string]any{"Current": 1.0, "Total": 3.0}, progress["TotalProgress"])
+}
+
+func TestAPIClearsLastProgressOnIdle(t *testing.T) {
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	channels.msg <- MessageFromString("build/BuilderA", "1/2 1/3 main/packageA 1.0.0-r0")
+	publisher.makeStep()
+	channels.msg <- MessageFromString("build/BuilderA", "idle")
+	publisher.makeStep()
+
+	recorder := requestAPI(t, publisher, "/api/builders/BuilderA")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var builder map[string]any
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &builder))
+	assert.Nil(t, builder["LastProgress"])
+}
+
+func TestAPIReturnsNotFoundForUnknownBuilder(t *testing.T) {
+	publisher, _, cancel := createPublisher(t)
+	defer cancel()
+
+	recorder := requestAPI(t, publisher, "/api/builders/unknown")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+// requestAPI performs a GET request against the publisher's handler while
+// stepping the publisher loop once to answer it.
+func requestAPI(t *testing.T, publisher *BuildStatusPublisher, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publisher.handler().ServeHTTP(recorder, request)
+	}()
+
+	publisher.makeStep()
+	<-done
+
+	return recorder
+}
@@ -2,29 +2,43 @@ package backend
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"net"
+	"sync"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog/log"
 )
 
-func Run(ctx context.Context, client mqtt.Client, msgs chan Message) error {
-	if t := client.Connect(); t.Wait() && t.Error() != nil {
-		return fmt.Errorf("error connecting to broker: %w", t.Error())
-	}
+// Run serves the build status fed by sources until ctx is cancelled or one
+// of the sources fails, in which case its error is returned.
+func Run(ctx context.Context, listener net.Listener, sources []Source, opts ...PublisherOption) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	if t := client.Subscribe("build/#", 0,
-		MessageHandler(
-			ctx,
-			msgs,
-		)); t.Wait() && t.Error() != nil {
-		return fmt.Errorf("error subscribing to topic: %w", t.Error())
-	}
+	msgs := make(chan Message, 16)
+	publisher := NewBuildStatusPublisher(msgs, opts...)
 
-	publisher := NewBuildStatusPublisher(msgs)
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := source.Run(ctx, msgs); err != nil {
+				cancel(err)
+			}
+		}()
+	}
 
 	log.Info().Msg("Server started")
 
-	publisher.ListenHTTP(ctx)
-	return ctx.Err()
+	publisher.ListenHTTP(ctx, listener)
+
+	cancel(nil)
+	wg.Wait()
+
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCombinesSources(t *testing.T) {
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	source := func(builder string) Source {
+		return SourceFunc(func(ctx context.Context, msgs chan<- Message) error {
+			deliver(ctx, msgs, MessageFromString("build/"+builder, "pulling git"))
+			return nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(ctx, listener, []Source{source("BuilderA"), source("BuilderB")})
+	}()
+
+	require.Eventually(func() bool {
+		response, err := http.Get("http://" + listener.Addr().String() + "/api/builders")
+		if err != nil {
+			return false
+		}
+		defer response.Body.Close()
+
+		var builders []struct{ Builder string }
+		if err := json.NewDecoder(response.Body).Decode(&builders); err != nil {
+			return false
+		}
+		return len(builders) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(<-errCh)
+}
+
+func TestRunStopsSourcesOnShutdown(t *testing.T) {
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	source := SourceFunc(func(ctx context.Context, msgs chan<- Message) error {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(ctx, listener, []Source{source})
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-errCh:
+		require.NoError(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Run returned before the source stopped")
+	}
+}
+
+func TestRunReturnsSourceError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	failing := SourceFunc(func(ctx context.Context, msgs chan<- Message) error {
+		return errors.New("connection refused")
+	})
+
+	err = Run(context.Background(), listener, []Source{failing})
+
+	assert.ErrorContains(t, err, "connection refused")
+}
@@ -0,0 +1,221 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+const disconnectTimeout = time.Second
+
+// MQTTSource subscribes to the build topics of an MQTT broker and reports
+// its connection state as system messages.
+type MQTTSource struct {
+	client mqtt.Client
+	config MQTTConfig
+	status *BrokerStatus
+
+	// recorder captures the received traffic if recording is enabled.
+	recorder *Recorder
+	now      func() time.Time
+
+	// Set by Run before connecting, read by the connection handlers.
+	ctx  context.Context
+	msgs chan<- Message
+	// reconnecting is set once Run subscribed on the first connection.
+	reconnecting atomic.Bool
+}
+
+type MQTTSourceOption func(*MQTTSource)
+
+// WithMQTTClock sets the clock used to timestamp received messages and the
+// connection state changes.
+func WithMQTTClock(now func() time.Time) MQTTSourceOption {
+	return func(s *MQTTSource) {
+		s.now = now
+	}
+}
+
+// NewMQTTSource creates a source for the configured broker. The client
+// reconnects on its own and keeps its session across reconnects.
+func NewMQTTSource(config MQTTConfig, sourceOpts ...MQTTSourceOption) (*MQTTSource, error) {
+	opts, err := NewClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &MQTTSource{
+		config: config,
+		status: NewBrokerStatus(),
+		now:    time.Now,
+	}
+	for _, opt := range sourceOpts {
+		opt(source)
+	}
+
+	opts.
+		SetClientID(fmt.Sprintf("build-server-status-%d", time.Now().UnixMicro())).
+		SetAutoReconnect(true).
+		SetCleanSession(false).
+		SetMaxReconnectInterval(1 * time.Minute).
+		SetOnConnectHandler(source.onConnect).
+		SetConnectionLostHandler(source.onConnectionLost)
+
+	source.client = mqtt.NewClient(opts)
+
+	if config.Record != "" {
+		source.recorder, err = NewRecorder(config.Record, config.RecordMaxSize, config.RecordMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return source, nil
+}
+
+// Status returns the broker connection state for the readiness probe.
+func (s *MQTTSource) Status() *BrokerStatus {
+	return s.status
+}
+
+func (s *MQTTSource) Run(ctx context.Context, msgs chan<- Message) error {
+	s.ctx = ctx
+	s.msgs = msgs
+
+	if s.recorder != nil {
+		defer s.recorder.Close()
+	}
+
+	if t := s.client.Connect(); t.Wait() && t.Error() != nil {
+		return fmt.Errorf("error connecting to broker: %w", t.Error())
+	}
+
+	if err := s.subscribe(); err != nil {
+		s.client.Disconnect(0)
+		return err
+	}
+	s.reconnecting.Store(true)
+
+	<-ctx.Done()
+
+	log.Info().Msg("Disconnecting from broker")
+	if t := s.client.Unsubscribe(s.config.Topic); !t.WaitTimeout(disconnectTimeout) || t.Error() != nil {
+		log.Warn().Err(t.Error()).Msg("Failed to unsubscribe from topic")
+	}
+	s.client.Disconnect(uint(disconnectTimeout.Milliseconds()))
+
+	return nil
+}
+
+func (s *MQTTSource) subscribe() error {
+	if t := s.client.Subscribe(s.config.Topic, s.config.QoS,
+		MessageHandler(
+			s.ctx,
+			s.msgs,
+			s.recorder,
+			s.now,
+		)); t.Wait() && t.Error() != nil {
+		return fmt.Errorf("error subscribing to topic: %w", t.Error())
+	}
+	s.status.Subscribed()
+
+	return nil
+}
+
+// onConnect runs in its own goroutine for the first connection and every
+// reconnect. A broker restarted without persistence has lost the session,
+// so the subscription is renewed on reconnects.
+func (s *MQTTSource) onConnect(c mqtt.Client) {
+	log.Info().Msg("Connected to broker")
+	s.status.Connected()
+	deliver(s.ctx, s.msgs, Stamp(NewSystemMessage("mqtt-connected", "Connected to broker"), s.now(), OriginSystem))
+
+	if s.reconnecting.Load() {
+		if err := s.subscribe(); err != nil {
+			log.Error().Err(err).Msg("Failed to renew subscription")
+		}
+	}
+}
+
+func (s *MQTTSource) onConnectionLost(c mqtt.Client, err error) {
+	log.
+		Error().
+		Err(fmt.Errorf("Connection to broker lost: %w", err)).
+		Msg("")
+	s.status.Disconnected(err)
+	deliver(s.ctx, s.msgs, Stamp(NewSystemMessage("mqtt-disconnected", "Connection to broker lost"), s.now(), OriginSystem))
+}
+
+// NewClientOptions returns the MQTT client options for connecting to the
+// configured broker, including TLS and authentication settings.
+func NewClientOptions(config MQTTConfig) (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions().AddBroker(config.Broker)
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if config.Username != "" {
+		opts.SetUsername(config.Username)
+	}
+	if config.PasswordFile != "" {
+		password, err := os.ReadFile(config.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading password file: %w", err)
+		}
+		opts.SetPassword(strings.TrimRight(string(password), "\r\n"))
+	}
+
+	return opts, nil
+}
+
+// newTLSConfig returns nil if no TLS settings are configured, in which case
+// TLS brokers are verified against the system certificate pool.
+func newTLSConfig(config MQTTConfig) (*tls.Config, error) {
+	if config.CAFile == "" && config.CertFile == "" && config.KeyFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,415 @@
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClientOptionsConnectsToTLSBroker(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	pki := newTestPKI(t)
+	broker := startTLSBroker(t, pki, "bss", "secret")
+
+	config := DefaultConfig().MQTT
+	config.Broker = "ssl://" + broker.addr
+	config.CAFile = pki.caFile
+	config.CertFile = pki.clientCertFile
+	config.KeyFile = pki.clientKeyFile
+	config.Username = "bss"
+	config.PasswordFile = writeFile(t, "password", "secret\n")
+
+	opts, err := NewClientOptions(config)
+	require.NoError(err)
+
+	client := mqtt.NewClient(opts.SetClientID("test").SetAutoReconnect(false))
+	token := client.Connect()
+	require.True(token.WaitTimeout(5 * time.Second))
+	require.NoError(token.Error())
+	client.Disconnect(0)
+
+	connect := <-broker.connects
+	assert.Equal("bss", connect.username)
+	assert.Equal("secret", connect.password)
+	assert.Equal("bss-client", connect.clientCN)
+}
+
+func TestNewClientOptionsRejectedWithWrongPassword(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := startTLSBroker(t, pki, "bss", "secret")
+
+	config := DefaultConfig().MQTT
+	config.Broker = "ssl://" + broker.addr
+	config.CAFile = pki.caFile
+	config.CertFile = pki.clientCertFile
+	config.KeyFile = pki.clientKeyFile
+	config.Username = "bss"
+	config.PasswordFile = writeFile(t, "password", "wrong")
+
+	opts, err := NewClientOptions(config)
+	require.NoError(t, err)
+
+	client := mqtt.NewClient(opts.SetClientID("test").SetAutoReconnect(false))
+	token := client.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	assert.Error(t, token.Error())
+}
+
+func TestNewClientOptionsRequiresTrustedBroker(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := startTLSBroker(t, pki, "", "")
+
+	config := DefaultConfig().MQTT
+	config.Broker = "ssl://" + broker.addr
+	config.CAFile = newTestPKI(t).caFile
+	config.CertFile = pki.clientCertFile
+	config.KeyFile = pki.clientKeyFile
+
+	opts, err := NewClientOptions(config)
+	require.NoError(t, err)
+
+	client := mqtt.NewClient(opts.SetClientID("test").SetAutoReconnect(false))
+	token := client.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	assert.ErrorContains(t, token.Error(), "certificate")
+}
+
+func TestNewClientOptionsWithoutTLS(t *testing.T) {
+	opts, err := NewClientOptions(DefaultConfig().MQTT)
+	require.NoError(t, err)
+
+	assert.Nil(t, opts.TLSConfig)
+	assert.Equal(t, "tcp://msg.alpinelinux.org:1883", opts.Servers[0].String())
+}
+
+func TestNewClientOptionsErrors(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name   string
+		config MQTTConfig
+		err    string
+	}{
+		{name: "missing key", config: MQTTConfig{CertFile: pki.clientCertFile}, err: "set together"},
+		{name: "missing CA", config: MQTTConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, err: "CA bundle"},
+		{name: "empty CA", config: MQTTConfig{CAFile: writeFile(t, "ca.pem", "")}, err: "no certificates"},
+		{name: "missing password", config: MQTTConfig{Username: "bss", PasswordFile: filepath.Join(t.TempDir(), "missing")}, err: "password file"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewClientOptions(tt.config)
+		assert.ErrorContains(t, err, tt.err, tt.name)
+	}
+}
+
+func TestMQTTSourceSubscribesUntilCancelled(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	client := &fakeMQTTClient{}
+	source := &MQTTSource{client: client, config: DefaultConfig().MQTT, status: NewBrokerStatus()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- source.Run(ctx, make(chan Message, 1))
+	}()
+
+	require.Eventually(func() bool {
+		return source.Status().Report().Subscribed
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		require.NoError(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("source did not return after context cancellation")
+	}
+
+	assert.Equal([]string{"build/#"}, client.subscribed)
+	assert.Equal([]string{"build/#"}, client.unsubscribed)
+	assert.True(client.disconnected)
+}
+
+func TestMQTTSourceReturnsConnectError(t *testing.T) {
+	client := &fakeMQTTClient{connectErr: errors.New("connection refused")}
+	source := &MQTTSource{client: client, config: DefaultConfig().MQTT, status: NewBrokerStatus()}
+
+	err := source.Run(context.Background(), make(chan Message, 1))
+
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestMQTTSourceReportsConnectionState(t *testing.T) {
+	msgs := make(chan Message, 2)
+	source := &MQTTSource{status: NewBrokerStatus(), now: func() time.Time { return testNow }, ctx: t.Context(), msgs: msgs}
+
+	source.onConnect(nil)
+	assert.True(t, source.Status().Report().Connected)
+	source.onConnectionLost(nil, errors.New("EOF"))
+	assert.False(t, source.Status().Report().Connected)
+
+	connected := (<-msgs).(SystemMessage)
+	assert.Equal(t, "mqtt-connected", connected.Status)
+	assert.Equal(t, testNow, connected.Received)
+	assert.Equal(t, "mqtt-disconnected", (<-msgs).(SystemMessage).Status)
+}
+
+func TestMQTTSourceResubscribesOnReconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &fakeMQTTClient{}
+	source := &MQTTSource{client: client, config: DefaultConfig().MQTT, status: NewBrokerStatus(), now: time.Now, ctx: t.Context(), msgs: make(chan Message, 4)}
+	source.onConnect(client)
+	assert.Empty(client.subscribed, "Run subscribes on the first connection")
+	source.reconnecting.Store(true)
+
+	source.onConnectionLost(client, errors.New("EOF"))
+	assert.False(source.Status().Report().Subscribed)
+
+	source.onConnect(client)
+	assert.Equal([]string{"build/#"}, client.subscribed)
+	assert.True(source.Status().Report().Ready)
+}
+
+// fakeMQTTClient implements the parts of mqtt.Client used by MQTTSource and
+// the simulator.
+type fakeMQTTClient struct {
+	mqtt.Client
+	connectErr   error
+	publishErr   error
+	published    []string
+	retained     []string
+	subscribed   []string
+	unsubscribed []string
+	disconnected bool
+}
+
+func (c *fakeMQTTClient) Connect() mqtt.Token {
+	return doneToken{err: c.connectErr}
+}
+
+func (c *fakeMQTTClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.subscribed = append(c.subscribed, topic)
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published = append(c.published, topic)
+	if retained {
+		c.retained = append(c.retained, topic)
+	}
+	return doneToken{err: c.publishErr}
+}
+
+func (c *fakeMQTTClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.unsubscribed = append(c.unsubscribed, topics...)
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) Disconnect(quiesce uint) {
+	c.disconnected = true
+}
+
+type doneToken struct {
+	err error
+}
+
+func (t doneToken) Wait() bool {
+	return true
+}
+
+func (t doneToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func (t doneToken) Error() error {
+	return t.err
+}
+
+type testPKI struct {
+	caFile         string
+	caPool         *x509.CertPool
+	serverCert     tls.Certificate
+	clientCertFile string
+	clientKeyFile  string
+}
+
+// newTestPKI creates a CA with a server certificate for 127.0.0.1 and a
+// client certificate.
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bss-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, template *x509.Certificate) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		template.SerialNumber = big.NewInt(serial)
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	serverCertPEM, serverKeyPEM := issue(2, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "bss-test-broker"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	require.NoError(t, err)
+
+	clientCertPEM, clientKeyPEM := issue(3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "bss-client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return &testPKI{
+		caFile:         writeFile(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))),
+		caPool:         pool,
+		serverCert:     serverCert,
+		clientCertFile: writeFile(t, "client.pem", string(clientCertPEM)),
+		clientKeyFile:  writeFile(t, "client-key.pem", string(clientKeyPEM)),
+	}
+}
+
+type tlsBroker struct {
+	addr     string
+	connects chan brokerConnect
+}
+
+type brokerConnect struct {
+	username string
+	password string
+	clientCN string
+}
+
+// startTLSBroker starts a stand-in for a TLS-only MQTT broker that requires
+// a client certificate. It answers CONNECT packets and refuses them unless
+// the credentials match, then keeps the connection open.
+func startTLSBroker(t *testing.T, pki *testPKI, username, password string) *tlsBroker {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.serverCert},
+		ClientCAs:    pki.caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	broker := &tlsBroker{
+		addr:     listener.Addr().String(),
+		connects: make(chan brokerConnect, 1),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn.(*tls.Conn), username, password)
+		}
+	}()
+
+	return broker
+}
+
+func (b *tlsBroker) serve(conn *tls.Conn, username, password string) {
+	defer conn.Close()
+
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+
+	// Refused clients retry with MQTT 3.1, only the first attempt is kept.
+	select {
+	case b.connects <- brokerConnect{
+		username: connect.Username,
+		password: string(connect.Password),
+		clientCN: conn.ConnectionState().PeerCertificates[0].Subject.CommonName,
+	}:
+	default:
+	}
+
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	if connect.Username != username || string(connect.Password) != password {
+		connack.ReturnCode = packets.ErrRefusedNotAuthorised
+	}
+	if err := connack.Write(conn); err != nil {
+		return
+	}
+
+	for {
+		if _, err := packets.ReadPacket(conn); err != nil {
+			return
+		}
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture files record the raw MQTT traffic so it can be replayed later.
+// They are JSON Lines: one CaptureRecord object per line, in the order the
+// messages were received, for example
+//
+//	{"time":"2024-05-01T12:00:00.123456789Z","topic":"build/build-edge-x86_64","payload":"pulling git"}
+//
+// time is the receive time in RFC 3339 with nanoseconds, topic the full
+// MQTT topic and payload the message body as text. Readers must ignore
+// unknown fields, new fields may be added but existing ones do not change.
+//
+// When a file reaches its maximum size it is renamed to file.1, older
+// files shift to file.2 and so on, and the oldest is removed.
+
+const (
+	defaultCaptureMaxSize  = 100 << 20
+	defaultCaptureMaxFiles = 5
+
+	// maxCaptureLine bounds the length of a single record when reading.
+	maxCaptureLine = 16 << 20
+)
+
+// CaptureRecord is a single message in a capture file.
+type CaptureRecord struct {
+	Time    time.Time `json:"time"`
+	Topic   string    `json:"topic"`
+	Payload string    `json:"payload"`
+}
+
+// Recorder appends capture records to a file and rotates it by size. It is
+// safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewRecorder opens path for appending. maxSize is the size in bytes after
+// which the file is rotated and maxFiles the number of rotated files kept.
+func NewRecorder(path string, maxSize int64, maxFiles int) (*Recorder, error) {
+	r := &Recorder{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening capture file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening capture file: %w", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return nil
+}
+
+// Record appends a single record, rotating the file first if the record
+// would make it exceed the maximum size.
+func (r *Recorder) Record(record CaptureRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+
+	if r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing capture file: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("error closing capture file: %w", err)
+	}
+	r.file = nil
+
+	if r.maxFiles < 1 {
+		if err := os.Remove(r.path); err != nil {
+			return fmt.Errorf("error rotating capture file: %w", err)
+		}
+		return r.open()
+	}
+
+	os.Remove(r.rotatedPath(r.maxFiles))
+	for i := r.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(r.rotatedPath(i), r.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error rotating capture file: %w", err)
+		}
+	}
+	if err := os.Rename(r.path, r.rotatedPath(1)); err != nil {
+		return fmt.Errorf("error rotating capture file: %w", err)
+	}
+
+	return r.open()
+}
+
+func (r *Recorder) rotatedPath(n int) string {
+	return r.path + "." + strconv.Itoa(n)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+// CaptureReader reads the records of a capture file in order.
+type CaptureReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxCaptureLine)
+
+	return &CaptureReader{
+		scanner: scanner,
+	}
+}
+
+// Next returns the next record, or io.EOF at the end of the capture. Empty
+// lines are skipped.
+func (r *CaptureReader) Next() (CaptureRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record CaptureRecord
+		if err := json.Unmarshal(r.scanner.Bytes(), &record); err != nil {
+			return record, fmt.Errorf("line %d: %w", r.line, err)
+		}
+
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return CaptureRecord{}, fmt.Errorf("line %d: %w", r.line+1, err)
+	}
+
+	return CaptureRecord{}, io.EOF
+}
+
+// ParseByteSize parses sizes like 1048576, 512KiB or 100MiB.
+func ParseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"GiB", 1 << 30},
+		{"MiB", 1 << 20},
+		{"KiB", 1 << 10},
+		{"B", 1},
+	}
+
+	number, multiplier := s, int64(1)
+	for _, unit := range units {
+		if trimmed, ok := strings.CutSuffix(s, unit.suffix); ok {
+			number, multiplier = strings.TrimSpace(trimmed), unit.size
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return size * multiplier, nil
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderAppendsJSONLines(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	received := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+
+	recorder, err := NewRecorder(path, 1<<20, 1)
+	require.NoError(err)
+	require.NoError(recorder.Record(CaptureRecord{Time: received, Topic: "build/BuilderA", Payload: "pulling git"}))
+	require.NoError(recorder.Close())
+
+	// Reopening appends instead of truncating.
+	recorder, err = NewRecorder(path, 1<<20, 1)
+	require.NoError(err)
+	require.NoError(recorder.Record(CaptureRecord{Time: received, Topic: "build/BuilderA/state", Payload: "online"}))
+	require.NoError(recorder.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	assert.Equal(t,
+		`{"time":"2024-05-01T12:00:00.123456789Z","topic":"build/BuilderA","payload":"pulling git"}`+"\n"+
+			`{"time":"2024-05-01T12:00:00.123456789Z","topic":"build/BuilderA/state","payload":"online"}`+"\n",
+		string(data))
+}
+
+func TestRecorderRotatesBySize(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	record := func(payload string) CaptureRecord {
+		return CaptureRecord{Time: time.Unix(0, 0).UTC(), Topic: "build/BuilderA", Payload: payload}
+	}
+	line, err := json.Marshal(record("0"))
+	require.NoError(err)
+
+	// Two records fit into a file, two rotated files are kept.
+	recorder, err := NewRecorder(path, int64(2*(len(line)+1)), 2)
+	require.NoError(err)
+	for _, payload := range []string{"0", "1", "2", "3", "4", "5", "6"} {
+		require.NoError(recorder.Record(record(payload)))
+	}
+	require.NoError(recorder.Close())
+
+	assert.Equal([]string{"6"}, capturedPayloads(t, path))
+	assert.Equal([]string{"4", "5"}, capturedPayloads(t, path+".1"))
+	assert.Equal([]string{"2", "3"}, capturedPayloads(t, path+".2"))
+	assert.NoFileExists(path + ".3")
+}
+
+func TestRecorderRejectsRecordsAfterClose(t *testing.T) {
+	recorder, err := NewRecorder(filepath.Join(t.TempDir(), "capture.jsonl"), 1<<20, 1)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Close())
+
+	assert.ErrorIs(t, recorder.Record(CaptureRecord{}), os.ErrClosed)
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		size  int64
+	}{
+		{value: "1024", size: 1024},
+		{value: "10B", size: 10},
+		{value: "512KiB", size: 512 << 10},
+		{value: "100 MiB", size: 100 << 20},
+		{value: "2GiB", size: 2 << 30},
+	}
+
+	for _, tt := range tests {
+		size, err := ParseByteSize(tt.value)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.size, size, tt.value)
+	}
+
+	for _, invalid := range []string{"", "MiB", "-1", "10MB"} {
+		_, err := ParseByteSize(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func capturedPayloads(t *testing.T, path string) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var payloads []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record CaptureRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		payloads = append(payloads, record.Payload)
+	}
+	require.NoError(t, scanner.Err())
+
+	return payloads
+}
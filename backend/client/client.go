@@ -0,0 +1,310 @@
+// Package client follows the event stream of a build-server-status instance
+// and keeps a mirror of the state of its builders.
+package client
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"gitlab.alpinelinux.org/alpine/infra/build-server-status/backend"
+)
+
+const (
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultIdleTimeout = time.Minute
+	// defaultMaxMessages matches the number of messages an instance keeps
+	// per builder by default.
+	defaultMaxMessages = 3
+	defaultMaxErrors   = 10
+	defaultMaxRuns     = 10
+)
+
+// Client follows the /events stream of an instance with a
+// backend.StreamFollower and applies every message to its mirror of the
+// builders before passing it on.
+type Client struct {
+	url        string
+	httpClient *http.Client
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	idleTimeout time.Duration
+	maxMessages int
+	maxErrors   int
+	maxRuns     int
+
+	mu       sync.Mutex
+	builders map[string]*backend.BuilderSnapshot
+	runs     map[string]*backend.BuilderRuns
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to connect to the instance.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBackoff sets the range of the delay between reconnects.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithIdleTimeout sets how long the stream may stay silent before the
+// connection is considered lost. Instances send a keepalive every 15
+// seconds by default.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.idleTimeout = timeout
+	}
+}
+
+// WithMaxMessages sets the number of recent messages kept per builder.
+func WithMaxMessages(n int) Option {
+	return func(c *Client) {
+		c.maxMessages = n
+	}
+}
+
+// WithMaxErrors sets the number of recent build errors kept per builder.
+func WithMaxErrors(n int) Option {
+	return func(c *Client) {
+		c.maxErrors = n
+	}
+}
+
+// WithMaxRuns sets the number of ended runs kept per builder.
+func WithMaxRuns(n int) Option {
+	return func(c *Client) {
+		c.maxRuns = n
+	}
+}
+
+// New creates a client for the event stream at url, for example
+// https://build.alpinelinux.org/events.
+func New(url string, opts ...Option) *Client {
+	c := &Client{
+		url:         url,
+		httpClient:  &http.Client{},
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		idleTimeout: defaultIdleTimeout,
+		maxMessages: defaultMaxMessages,
+		maxErrors:   defaultMaxErrors,
+		maxRuns:     defaultMaxRuns,
+		builders:    map[string]*backend.BuilderSnapshot{},
+		runs:        map[string]*backend.BuilderRuns{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Run sends the messages of the stream to msgs until ctx is cancelled.
+// Connection failures are retried, so Run only returns once ctx is done.
+// The Client implements backend.Source.
+func (c *Client) Run(ctx context.Context, msgs chan<- backend.Message) error {
+	backend.NewStreamFollower(c.url, &clientStream{client: c, msgs: msgs},
+		backend.WithFollowerHTTPClient(c.httpClient),
+		backend.WithFollowerBackoff(c.minBackoff, c.maxBackoff),
+		backend.WithFollowerIdleTimeout(c.idleTimeout),
+	).Run(ctx)
+
+	return nil
+}
+
+// Builders returns the current state of all builders, sorted by name.
+func (c *Client) Builders() []backend.BuilderSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	builders := []backend.BuilderSnapshot{}
+	for _, name := range slices.Sorted(maps.Keys(c.builders)) {
+		builders = append(builders, copyBuilder(c.builders[name]))
+	}
+
+	return builders
+}
+
+// Builder returns the current state of the named builder.
+func (c *Client) Builder(name string) (backend.BuilderSnapshot, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	builder, ok := c.builders[name]
+	if !ok {
+		return backend.BuilderSnapshot{}, false
+	}
+
+	return copyBuilder(builder), true
+}
+
+// Runs returns the current run of the named builder and the runs that ended
+// since the client connected.
+func (c *Client) Runs(name string) (backend.BuilderRuns, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	runs, ok := c.runs[name]
+	if !ok {
+		return backend.BuilderRuns{}, false
+	}
+
+	r := backend.BuilderRuns{
+		Builder: runs.Builder,
+		Recent:  slices.Clone(runs.Recent),
+	}
+	if runs.Current != nil {
+		current := *runs.Current
+		r.Current = &current
+	}
+
+	return r, true
+}
+
+// clientStream applies the events of the stream to the mirror before
+// passing them on.
+type clientStream struct {
+	client *Client
+	msgs   chan<- backend.Message
+}
+
+func (s *clientStream) Connected(ctx context.Context) {}
+
+func (s *clientStream) Disconnected(ctx context.Context, err error) {}
+
+func (s *clientStream) Comment(ctx context.Context, text string) {
+	if text == backend.SnapshotComment {
+		// The events that follow replace the state we have.
+		s.client.reset()
+	}
+}
+
+func (s *clientStream) Event(ctx context.Context, event backend.Event) {
+	if _, ok := event.Msg.(backend.SnapshotMessage); ok {
+		// Sent after the SnapshotComment by current instances.
+		s.client.reset()
+		return
+	}
+
+	s.client.apply(event.Msg)
+	select {
+	case s.msgs <- event.Msg:
+	case <-ctx.Done():
+	}
+}
+
+func (c *Client) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.builders)
+	clear(c.runs)
+}
+
+// apply updates the mirror like the instance updates its own state.
+func (c *Client) apply(msg backend.Message) {
+	name := msg.BuilderName()
+	// System messages are not about a builder.
+	if name == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := msg.(backend.RemovedMessage); ok {
+		delete(c.builders, name)
+		delete(c.runs, name)
+		return
+	}
+
+	switch m := msg.(type) {
+	case backend.RunStartedMessage:
+		c.builderRuns(name).Current = &m.Run
+		return
+	case backend.RunEndedMessage:
+		runs := c.builderRuns(name)
+		runs.Current = nil
+		runs.Recent = append(runs.Recent, m.Run)
+		if len(runs.Recent) > c.maxRuns {
+			runs.Recent = runs.Recent[len(runs.Recent)-c.maxRuns:]
+		}
+		return
+	}
+
+	builder, ok := c.builders[name]
+	if !ok {
+		builder = &backend.BuilderSnapshot{Builder: name}
+		c.builders[name] = builder
+	}
+
+	switch m := msg.(type) {
+	case backend.StaleMessage:
+		builder.Stale = m.Stale
+		builder.LastSeen = m.LastSeen
+	case backend.RestoredMessage:
+		builder.Restored = m.Restored
+	case backend.BuildErrorMessage:
+		if m.Msg == "" {
+			builder.Error = nil
+		} else {
+			builder.Error = m
+			builder.Errors = backend.AppendError(builder.Errors, m, c.maxErrors)
+		}
+	case backend.ErrorHistoryMessage:
+		builder.Errors = nil
+		for _, err := range m.Errors {
+			builder.Errors = backend.AppendError(builder.Errors, err, c.maxErrors)
+		}
+	case backend.BuildStateMessage:
+		builder.State = m.State
+	case backend.IdleMessage:
+		builder.Msgs = []backend.Message{m}
+		builder.Error = nil
+		builder.LastProgress = nil
+	default:
+		builder.Msgs = append(builder.Msgs, msg)
+		if len(builder.Msgs) > c.maxMessages {
+			builder.Msgs = builder.Msgs[len(builder.Msgs)-c.maxMessages:]
+		}
+		if m, ok := msg.(backend.BuildStatusMessage); ok {
+			builder.LastProgress = &m
+		}
+	}
+}
+
+func (c *Client) builderRuns(name string) *backend.BuilderRuns {
+	runs, ok := c.runs[name]
+	if !ok {
+		runs = &backend.BuilderRuns{Builder: name}
+		c.runs[name] = runs
+	}
+
+	return runs
+}
+
+func copyBuilder(builder *backend.BuilderSnapshot) backend.BuilderSnapshot {
+	b := *builder
+	b.Msgs = slices.Clone(builder.Msgs)
+	b.Errors = slices.Clone(builder.Errors)
+	if builder.LastProgress != nil {
+		progress := *builder.LastProgress
+		b.LastProgress = &progress
+	}
+
+	return b
+}
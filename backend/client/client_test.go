@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.alpinelinux.org/alpine/infra/build-server-status/backend"
+)
+
+var _ backend.Source = (*Client)(nil)
+
+func builderNames(builders []backend.BuilderSnapshot) []string {
+	names := []string{}
+	for _, b := range builders {
+		names = append(names, b.Builder)
+	}
+
+	return names
+}
+
+func TestClientMirrorsInstance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	instanceMsgs := make(chan backend.Message)
+	instance := backend.NewBuildStatusPublisher(instanceMsgs)
+	go instance.ListenHTTP(ctx, listener)
+
+	send := func(topic, payload string) {
+		instanceMsgs <- backend.MessageFromString(topic, payload)
+	}
+	send("build/BuilderA/state", "online")
+	send("build/BuilderA", "pulling git")
+
+	client := New(fmt.Sprintf("http://%s/events", listener.Addr()), WithBackoff(time.Millisecond, 10*time.Millisecond))
+	msgs := make(chan backend.Message, 32)
+	go client.Run(ctx, msgs)
+
+	receive := func(msgType string) backend.Message {
+		t.Helper()
+		for {
+			select {
+			case msg := <-msgs:
+				if msg.Type() == msgType {
+					return msg
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("no %s message received", msgType)
+			}
+		}
+	}
+
+	receive("state")
+	builder, ok := client.Builder("BuilderA")
+	require.True(t, ok)
+	assert.Equal(t, "online", builder.State)
+	assert.Equal(t, []backend.Message{backend.MessageFromString("build/BuilderA", "pulling git")}, builder.Msgs)
+
+	send("build/BuilderB", "1/2 3/10 busybox 1.36.1-r0")
+	send("build/BuilderB/errors", `{"reponame":"main","pkgname":"busybox"}`)
+	receive("error")
+	builder, ok = client.Builder("BuilderB")
+	require.True(t, ok)
+	require.NotNil(t, builder.LastProgress)
+	assert.Equal(t, "busybox", builder.LastProgress.PackageName)
+	assert.Equal(t, "busybox", builder.Error.(backend.BuildErrorMessage).Pkgname)
+	assert.Equal(t, []string{"BuilderA", "BuilderB"}, builderNames(client.Builders()))
+
+	send("build/BuilderA/state", "")
+	send("build/BuilderA", "")
+	receive("removed")
+	assert.Equal(t, []string{"BuilderB"}, builderNames(client.Builders()))
+
+	send("build/BuilderB", "idle")
+	receive("idle")
+	builder, _ = client.Builder("BuilderB")
+	assert.Nil(t, builder.Error)
+	assert.Nil(t, builder.LastProgress)
+	assert.Len(t, builder.Msgs, 1)
+}
+
+func TestClientResumesAndReplacesStateOnSnapshot(t *testing.T) {
+	lastEventIDs := make(chan string, 3)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		switch requests.Add(1) {
+		case 1:
+			fmt.Fprint(w, ": connected\n\n: snapshot\n\n")
+			fmt.Fprint(w, "id: 5\ndata: {\"MsgType\":\"msg\",\"Msg\":\"pulling git\",\"Builder\":\"BuilderA\"}\n\n")
+		case 2:
+			// Resumed with the events missed in between.
+			fmt.Fprint(w, ": connected\n\n")
+			fmt.Fprint(w, "id: 6\ndata: {\"MsgType\":\"msg\",\"Msg\":\"pulling git\",\"Builder\":\"BuilderB\"}\n\n")
+		default:
+			// The missed events are no longer available, announced by the
+			// snapshot event alone.
+			fmt.Fprint(w, ": connected\n\n")
+			fmt.Fprint(w, "id: 9\ndata: {\"MsgType\":\"snapshot\"}\n\n")
+			fmt.Fprint(w, "id: 9\ndata: {\"MsgType\":\"msg\",\"Msg\":\"pulling git\",\"Builder\":\"BuilderC\"}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := New(server.URL, WithBackoff(time.Millisecond, time.Millisecond))
+	msgs := make(chan backend.Message, 32)
+	go client.Run(ctx, msgs)
+
+	var got []string
+	for range 3 {
+		select {
+		case msg := <-msgs:
+			got = append(got, msg.BuilderName())
+		case <-time.After(2 * time.Second):
+			t.Fatalf("missing messages, got %v", got)
+		}
+	}
+
+	assert.Equal(t, []string{"BuilderA", "BuilderB", "BuilderC"}, got)
+	assert.Equal(t, "", <-lastEventIDs)
+	assert.Equal(t, "5", <-lastEventIDs)
+	assert.Equal(t, "6", <-lastEventIDs)
+	assert.Equal(t, []string{"BuilderC"}, builderNames(client.Builders()))
+}
+
+func TestClientKeepsRecentMessages(t *testing.T) {
+	client := New("http://localhost/events", WithMaxMessages(2))
+
+	for _, payload := range []string{"pulling git", "upgrading system", "uploading packages"} {
+		client.apply(backend.MessageFromString("build/BuilderA", payload))
+	}
+	client.apply(backend.MessageFromString("build/BuilderA/errors", `{"pkgname":"busybox"}`))
+	client.apply(backend.MessageFromString("build/BuilderA/errors", ""))
+
+	builder, ok := client.Builder("BuilderA")
+	require.True(t, ok)
+	assert.Equal(t, []backend.Message{
+		backend.MessageFromString("build/BuilderA", "upgrading system"),
+		backend.MessageFromString("build/BuilderA", "uploading packages"),
+	}, builder.Msgs)
+	assert.Nil(t, builder.Error)
+	require.Len(t, builder.Errors, 1, "cleared errors stay in the history")
+	assert.Equal(t, "busybox", builder.Errors[0].Pkgname)
+
+	client.apply(backend.ErrorHistoryMessage{
+		GenericMessage: backend.GenericMessage{MsgType: "errors", Builder: "BuilderA"},
+		Errors: []backend.BuildErrorMessage{
+			backend.MessageFromString("build/BuilderA/errors", `{"pkgname":"musl"}`).(backend.BuildErrorMessage),
+			backend.MessageFromString("build/BuilderA/errors", `{"pkgname":"openssl"}`).(backend.BuildErrorMessage),
+		},
+	})
+	builder, _ = client.Builder("BuilderA")
+	require.Len(t, builder.Errors, 2)
+	assert.Equal(t, "musl", builder.Errors[0].Pkgname)
+
+	lastSeen := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	client.apply(backend.StaleMessage{
+		GenericMessage: backend.GenericMessage{MsgType: "stale", Builder: "BuilderA"},
+		Stale:          true,
+		LastSeen:       lastSeen,
+	})
+	builder, _ = client.Builder("BuilderA")
+	assert.True(t, builder.Stale)
+	assert.Equal(t, lastSeen, builder.LastSeen)
+	assert.Len(t, builder.Msgs, 2, "stale messages are not activity")
+
+	client.apply(backend.RestoredMessage{
+		GenericMessage: backend.GenericMessage{MsgType: "restored", Builder: "BuilderA"},
+		Restored:       true,
+	})
+	builder, _ = client.Builder("BuilderA")
+	assert.True(t, builder.Restored)
+
+	run := backend.BuildRun{Builder: "BuilderA", Total: 3}
+	client.apply(backend.RunStartedMessage{
+		GenericMessage: backend.GenericMessage{MsgType: "run-started", Builder: "BuilderA"},
+		Run:            run,
+	})
+	runs, ok := client.Runs("BuilderA")
+	require.True(t, ok)
+	assert.Equal(t, &run, runs.Current)
+	run.End = backend.RunEndIdle
+	client.apply(backend.RunEndedMessage{
+		GenericMessage: backend.GenericMessage{MsgType: "run-ended", Builder: "BuilderA"},
+		Run:            run,
+	})
+	runs, _ = client.Runs("BuilderA")
+	assert.Nil(t, runs.Current)
+	assert.Equal(t, []backend.BuildRun{run}, runs.Recent)
+	builder, _ = client.Builder("BuilderA")
+	assert.Len(t, builder.Msgs, 2, "runs are not activity")
+
+	builder.Msgs[0] = nil
+	builder.Errors[0].Pkgname = ""
+	builder, _ = client.Builder("BuilderA")
+	assert.NotNil(t, builder.Msgs[0], "returned state is a copy")
+	assert.Equal(t, "musl", builder.Errors[0].Pkgname, "returned state is a copy")
+}
+
+func TestClientRetriesFailedConnections(t *testing.T) {
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requests <- struct{}{}:
+		default:
+		}
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- New(server.URL, WithBackoff(time.Millisecond, time.Millisecond)).Run(ctx, make(chan backend.Message))
+	}()
+
+	for range 3 {
+		select {
+		case <-requests:
+		case <-time.After(2 * time.Second):
+			t.Fatal("client did not retry")
+		}
+	}
+	cancel()
+
+	assert.NoError(t, <-errCh)
+}
@@ -4,28 +4,123 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/pflag"
 	"gitlab.alpinelinux.org/alpine/infra/build-server-status/backend"
 )
 
+// flagKeys maps command line flags to the configuration keys they override.
+var flagKeys = map[string]string{
+	"log-level":       "log_level",
+	"sources":         "sources",
+	"broker":          "mqtt.broker",
+	"ca-file":         "mqtt.ca_file",
+	"cert-file":       "mqtt.cert_file",
+	"key-file":        "mqtt.key_file",
+	"username":        "mqtt.username",
+	"password-file":   "mqtt.password_file",
+	"record":          "mqtt.record",
+	"embedded-broker": "embedded_broker.listen",
+	"ingest-tokens":   "ingest.token_file",
+	"relay-url":       "relay.url",
+	"listen":          "http.listen",
+	"socket-mode":     "http.socket_mode",
+	"static-dir":      "http.static_dir",
+	"queue-size":      "publisher.queue_size",
+	"overflow-policy": "publisher.overflow_policy",
+	"stale-after":     "publisher.stale_after",
+	"state-file":      "publisher.state_file",
+}
+
+// commands maps subcommands to their entry points. Without a subcommand the
+// server is started with the configured sources.
+var commands = map[string]func(args []string){
+	"replay":   replayMain,
+	"simulate": simulateMain,
+}
+
 func main() {
-	var levelFlag string
-	pflag.StringVarP(&levelFlag, "log-level", "l", "info", "Log level verbosity")
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
+	serveMain(os.Args[1:])
+}
+
+func serveMain(args []string) {
+	defaults := backend.DefaultConfig()
+
+	flags, configPath := newFlagSet("build-server-status")
+	flags.String("sources", strings.Join(defaults.Sources, ","), "Comma separated list of message sources: "+strings.Join(backend.SourceNames, ", "))
+	addMQTTFlags(flags)
+	flags.String("record", defaults.MQTT.Record, "Append received MQTT traffic to this capture file")
+	flags.String("embedded-broker", defaults.EmbeddedBroker.Listen, "Run an MQTT broker on this address and subscribe to it")
+	flags.String("ingest-tokens", defaults.Ingest.TokenFile, "File with the bearer tokens accepted by the ingest source")
+	flags.String("relay-url", defaults.Relay.URL, "The /events endpoint of the instance followed by the relay source")
+
+	flags.Parse(args)
+
+	config := setup(flags, *configPath)
+
+	sources, publisherOpts, err := backend.NewSources(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid source settings")
+	}
+
+	serve(config, sources, publisherOpts...)
+}
+
+// newFlagSet returns a flag set with the flags shared by all commands that
+// serve the build status.
+func newFlagSet(name string) (*pflag.FlagSet, *string) {
+	defaults := backend.DefaultConfig()
+	flags := pflag.NewFlagSet(name, pflag.ExitOnError)
+
+	configPath := flags.StringP("config", "c", "", "Path to the YAML configuration file")
+	flags.StringP("log-level", "l", defaults.LogLevel, "Log level verbosity")
+	flags.Int("queue-size", defaults.Publisher.QueueSize, "Number of frames buffered per subscriber")
+	flags.String("overflow-policy", defaults.Publisher.OverflowPolicy.String(), "What to do with subscribers that cannot keep up (disconnect or resync)")
+	flags.Duration("stale-after", defaults.Publisher.StaleAfter, "Mark builders stale when silent for this long, 0 disables")
+	flags.String("state-file", defaults.Publisher.StateFile, "Keep the state of the builders in this file across restarts")
+	flags.String("listen", defaults.HTTP.Listen, "Address to listen on: host:port, unix:/path or systemd")
+	flags.String("socket-mode", fmt.Sprintf("%04o", defaults.HTTP.SocketMode), "Permissions of the unix socket")
+	flags.String("static-dir", defaults.HTTP.StaticDir, "Serve the frontend from this directory instead of the embedded assets")
+
+	return flags, configPath
+}
 
-	pflag.Parse()
+// addMQTTFlags adds the flags for connecting to the MQTT broker.
+func addMQTTFlags(flags *pflag.FlagSet) {
+	defaults := backend.DefaultConfig()
 
-	logLevel, err := zerolog.ParseLevel(levelFlag)
+	flags.String("broker", defaults.MQTT.Broker, "URL of the MQTT broker")
+	flags.String("ca-file", defaults.MQTT.CAFile, "CA bundle to verify the MQTT broker")
+	flags.String("cert-file", defaults.MQTT.CertFile, "Client certificate for the MQTT broker")
+	flags.String("key-file", defaults.MQTT.KeyFile, "Key of the client certificate")
+	flags.String("username", defaults.MQTT.Username, "Username for the MQTT broker")
+	flags.String("password-file", defaults.MQTT.PasswordFile, "File containing the password for the MQTT broker")
+}
 
+// setup loads the configuration and sets up logging, exiting on invalid
+// settings.
+func setup(flags *pflag.FlagSet, configPath string) backend.Config {
+	config, err := loadConfig(flags, configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: unknown log level: %s\n", levelFlag)
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
 		os.Exit(1)
 	}
 
+	logLevel, _ := zerolog.ParseLevel(config.LogLevel)
+
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.SetGlobalLevel(logLevel)
 	log.Logger = log.Output(zerolog.ConsoleWriter{
@@ -34,38 +129,62 @@ func main() {
 	})
 
 	log.Info().Msgf("Logging with loglevel %s", logLevel)
-	broker := os.Getenv("BSS_MQTT_BROKER")
-	if broker == "" {
-		broker = "tcp://msg.alpinelinux.org:1883"
+
+	return config
+}
+
+// serve runs the server with the given sources until it is interrupted.
+func serve(config backend.Config, sources []backend.Source, publisherOpts ...backend.PublisherOption) {
+	publisherOpts = append(publisherOpts, config.Publisher.Options()...)
+	if config.HTTP.StaticDir != "" {
+		log.Info().Msgf("Serving frontend from %s", config.HTTP.StaticDir)
+		publisherOpts = append(publisherOpts, backend.WithStaticFS(os.DirFS(config.HTTP.StaticDir)))
 	}
-	msgs := make(chan backend.Message, 16)
-
-	opts := mqtt.
-		NewClientOptions().
-		AddBroker(broker).
-		SetClientID(fmt.Sprintf("build-server-status-%d", time.Now().UnixMicro())).
-		SetAutoReconnect(true).
-		SetCleanSession(false).
-		SetMaxReconnectInterval(1 * time.Minute).
-		SetOnConnectHandler(func(c mqtt.Client) {
-			log.Info().Msg("Connected to broker")
-			msgs <- backend.NewSystemMessage("mqtt-connected", "Connected to broker")
-		}).
-		SetConnectionLostHandler(func(c mqtt.Client, err error) {
-			log.
-				Error().
-				Err(fmt.Errorf("Connection to broker lost: %w", err)).
-				Msg("")
-			msgs <- backend.NewSystemMessage("mqtt-disconnected", "Connection to broker lost")
-		})
-
-	client := mqtt.NewClient(
-		opts,
-	)
-
-	ctx := context.Background()
-	err = backend.Run(ctx, client, msgs)
+
+	listener, err := backend.Listen(config.HTTP.Listen, config.HTTP.SocketMode)
 	if err != nil {
-		panic(err)
+		log.Fatal().Err(err).Msgf("Failed to listen on %s", config.HTTP.Listen)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := backend.Run(ctx, listener, sources, publisherOpts...); err != nil {
+		log.Error().Err(err).Msg("Server failed")
+		os.Exit(1)
 	}
+
+	log.Info().Msg("Server stopped")
+}
+
+// loadConfig reads the configuration file if given and applies the
+// environment and command line overrides on top, in that order.
+func loadConfig(flags *pflag.FlagSet, path string) (backend.Config, error) {
+	config := backend.DefaultConfig()
+	if path != "" {
+		var err error
+		if config, err = backend.LoadConfig(path); err != nil {
+			return config, err
+		}
+	}
+
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+
+	var flagErr error
+	flags.Visit(func(f *pflag.Flag) {
+		key, ok := flagKeys[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := config.Set(key, f.Value.String()); err != nil {
+			flagErr = fmt.Errorf("--%s: %w", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return config, flagErr
+	}
+
+	return config, config.Validate()
 }
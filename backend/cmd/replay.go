@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.alpinelinux.org/alpine/infra/build-server-status/backend"
+)
+
+// replayMain serves the build status from capture files recorded with
+// --record. Sending SIGUSR1 pauses and resumes the replay.
+func replayMain(args []string) {
+	flags, configPath := newFlagSet("build-server-status replay")
+	speed := flags.String("speed", "1", "Replay speed as a multiple of the recorded pace, or max")
+	loop := flags.Bool("loop", false, "Start over after the last capture file")
+	paused := flags.Bool("paused", false, "Start paused until SIGUSR1 is received")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: build-server-status replay [flags] capture.jsonl...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	replaySpeed, err := backend.ParseReplaySpeed(*speed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: --speed: %s\n", err)
+		os.Exit(1)
+	}
+
+	config := setup(flags, *configPath)
+
+	source := backend.NewReplaySource(
+		flags.Args(),
+		backend.WithReplaySpeed(replaySpeed),
+		backend.WithReplayLoop(*loop),
+		backend.WithReplayPaused(*paused),
+	)
+	go togglePauseOnSignal(source)
+
+	serve(config, []backend.Source{source})
+}
+
+func togglePauseOnSignal(source *backend.ReplaySource) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	for range signals {
+		if source.TogglePause() {
+			log.Info().Msg("Replay paused")
+		} else {
+			log.Info().Msg("Replay resumed")
+		}
+	}
+}
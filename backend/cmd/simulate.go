@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+	"gitlab.alpinelinux.org/alpine/infra/build-server-status/backend"
+)
+
+// simulateMain serves the build status of simulated builders, or publishes
+// their traffic to the configured MQTT broker with --publish. Publishing to
+// the default broker is refused, as it carries the traffic of the real
+// builders.
+func simulateMain(args []string) {
+	flags, configPath := newFlagSet("build-server-status simulate")
+	addMQTTFlags(flags)
+	seed := flags.Uint64("seed", 0, "Seed for reproducible traffic, random if 0")
+	builders := flags.Int("builders", 6, "Number of simulated builders")
+	speed := flags.Float64("speed", 1, "Speed up the simulation by this factor")
+	publish := flags.Bool("publish", false, "Publish to the MQTT broker instead of serving the build status")
+
+	flags.Parse(args)
+
+	if *builders < 1 || *speed <= 0 {
+		fmt.Fprintf(os.Stderr, "fatal: --builders and --speed must be positive\n")
+		os.Exit(1)
+	}
+
+	config := setup(flags, *configPath)
+	if *publish && config.MQTT.Broker == backend.DefaultConfig().MQTT.Broker {
+		fmt.Fprintf(os.Stderr, "fatal: --publish needs a --broker other than the default %s\n", config.MQTT.Broker)
+		os.Exit(1)
+	}
+
+	if *seed == 0 {
+		*seed = uint64(time.Now().UnixNano())
+	}
+	log.Info().Msgf("Simulating %d builders with --seed %d", *builders, *seed)
+
+	simulator := backend.NewSimulator(
+		backend.WithSimulatorSeed(*seed),
+		backend.WithSimulatorBuilders(*builders),
+		backend.WithSimulatorSpeed(*speed),
+	)
+
+	if !*publish {
+		serve(config, []backend.Source{simulator})
+		return
+	}
+
+	opts, err := backend.NewClientOptions(config.MQTT)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid MQTT settings")
+	}
+	client := mqtt.NewClient(opts.SetClientID(fmt.Sprintf("build-server-status-simulator-%d", time.Now().UnixMicro())))
+	if t := client.Connect(); t.Wait() && t.Error() != nil {
+		log.Fatal().Err(t.Error()).Msg("Failed to connect to broker")
+	}
+	defer client.Disconnect(1000)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info().Msgf("Publishing to %s", config.MQTT.Broker)
+	if err := simulator.Publish(ctx, client, config.MQTT.QoS); err != nil {
+		log.Error().Err(err).Msg("Simulation failed")
+		os.Exit(1)
+	}
+}
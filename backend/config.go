@@ -0,0 +1,486 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all settings of the service. It is read from a YAML file
+// and can be overridden per key from the environment and the command line.
+type Config struct {
+	LogLevel       string
+	Sources        []string
+	MQTT           MQTTConfig
+	EmbeddedBroker EmbeddedBrokerConfig
+	Ingest         IngestConfig
+	Relay          RelayConfig
+	HTTP           HTTPConfig
+	Publisher      PublisherConfig
+}
+
+type MQTTConfig struct {
+	Broker       string
+	Topic        string
+	QoS          byte
+	CAFile       string
+	CertFile     string
+	KeyFile      string
+	Username     string
+	PasswordFile string
+
+	// Record appends the received traffic to this capture file.
+	Record         string
+	RecordMaxSize  int64
+	RecordMaxFiles int
+}
+
+// EmbeddedBrokerConfig enables the embedded MQTT broker if Listen is set.
+// The mqtt source then connects to it instead of mqtt.broker.
+type EmbeddedBrokerConfig struct {
+	Listen string
+}
+
+// IngestConfig configures the ingest source accepting messages over HTTP.
+type IngestConfig struct {
+	TokenFile string
+}
+
+// RelayConfig configures the relay source following another instance.
+type RelayConfig struct {
+	URL string
+	// IdleTimeout drops the connection when upstream sends nothing, not
+	// even its keepalives, for this long. It has to be longer than the
+	// publisher.ping_interval of the upstream instance.
+	IdleTimeout time.Duration
+}
+
+type HTTPConfig struct {
+	Listen     string
+	SocketMode fs.FileMode
+	StaticDir  string
+}
+
+type PublisherConfig struct {
+	MaxMessages    int
+	MaxErrors      int
+	MaxRuns        int
+	HistorySize    int
+	PingInterval   time.Duration
+	QueueSize      int
+	OverflowPolicy OverflowPolicy
+	// StaleAfter and StaleAfterStates configure when silent builders are
+	// marked stale, see WithStaleAfter.
+	StaleAfter       time.Duration
+	StaleAfterStates map[string]time.Duration
+	// StateFile is where the state of the builders is kept across
+	// restarts, see WithStateFile.
+	StateFile         string
+	StateSaveInterval time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		LogLevel: "info",
+		Sources:  []string{"mqtt"},
+		MQTT: MQTTConfig{
+			Broker: "tcp://msg.alpinelinux.org:1883",
+			Topic:  "build/#",
+			QoS:    0,
+
+			RecordMaxSize:  defaultCaptureMaxSize,
+			RecordMaxFiles: defaultCaptureMaxFiles,
+		},
+		Relay: RelayConfig{
+			IdleTimeout: defaultFollowerIdleTimeout,
+		},
+		HTTP: HTTPConfig{
+			Listen:     "0.0.0.0:8080",
+			SocketMode: 0o660,
+		},
+		Publisher: PublisherConfig{
+			MaxMessages:    defaultMaxMessages,
+			MaxErrors:      defaultMaxErrors,
+			MaxRuns:        defaultMaxRuns,
+			HistorySize:    defaultHistorySize,
+			PingInterval:   defaultPingInterval,
+			QueueSize:      defaultQueueSize,
+			OverflowPolicy: OverflowDisconnect,
+
+			StateSaveInterval: defaultStateSaveInterval,
+		},
+	}
+}
+
+// configKeys maps every key of the configuration file to a setter parsing
+// its value. The same keys are used for environment variables, where
+// mqtt.broker becomes BSS_MQTT_BROKER.
+var configKeys = map[string]func(c *Config, value string) error{
+	"log_level": func(c *Config, value string) error {
+		if _, err := zerolog.ParseLevel(value); err != nil {
+			return err
+		}
+		c.LogLevel = value
+		return nil
+	},
+	"sources": func(c *Config, value string) error {
+		c.Sources = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Sources = append(c.Sources, name)
+			}
+		}
+		return nil
+	},
+	"mqtt.broker": func(c *Config, value string) error {
+		c.MQTT.Broker = value
+		return nil
+	},
+	"mqtt.topic": func(c *Config, value string) error {
+		c.MQTT.Topic = value
+		return nil
+	},
+	"mqtt.qos": func(c *Config, value string) error {
+		qos, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return err
+		}
+		c.MQTT.QoS = byte(qos)
+		return nil
+	},
+	"mqtt.ca_file": func(c *Config, value string) error {
+		c.MQTT.CAFile = value
+		return nil
+	},
+	"mqtt.cert_file": func(c *Config, value string) error {
+		c.MQTT.CertFile = value
+		return nil
+	},
+	"mqtt.key_file": func(c *Config, value string) error {
+		c.MQTT.KeyFile = value
+		return nil
+	},
+	"mqtt.username": func(c *Config, value string) error {
+		c.MQTT.Username = value
+		return nil
+	},
+	"mqtt.password_file": func(c *Config, value string) error {
+		c.MQTT.PasswordFile = value
+		return nil
+	},
+	"mqtt.record": func(c *Config, value string) error {
+		c.MQTT.Record = value
+		return nil
+	},
+	"mqtt.record_max_size": func(c *Config, value string) (err error) {
+		c.MQTT.RecordMaxSize, err = ParseByteSize(value)
+		return err
+	},
+	"mqtt.record_max_files": func(c *Config, value string) (err error) {
+		c.MQTT.RecordMaxFiles, err = strconv.Atoi(value)
+		return err
+	},
+	"embedded_broker.listen": func(c *Config, value string) error {
+		c.EmbeddedBroker.Listen = value
+		return nil
+	},
+	"ingest.token_file": func(c *Config, value string) error {
+		c.Ingest.TokenFile = value
+		return nil
+	},
+	"relay.url": func(c *Config, value string) error {
+		c.Relay.URL = value
+		return nil
+	},
+	"relay.idle_timeout": func(c *Config, value string) (err error) {
+		c.Relay.IdleTimeout, err = time.ParseDuration(value)
+		return err
+	},
+	"http.listen": func(c *Config, value string) error {
+		c.HTTP.Listen = value
+		return nil
+	},
+	"http.socket_mode": func(c *Config, value string) (err error) {
+		c.HTTP.SocketMode, err = ParseSocketMode(value)
+		return err
+	},
+	"http.static_dir": func(c *Config, value string) error {
+		c.HTTP.StaticDir = value
+		return nil
+	},
+	"publisher.max_messages": func(c *Config, value string) (err error) {
+		c.Publisher.MaxMessages, err = strconv.Atoi(value)
+		return err
+	},
+	"publisher.max_errors": func(c *Config, value string) (err error) {
+		c.Publisher.MaxErrors, err = strconv.Atoi(value)
+		return err
+	},
+	"publisher.max_runs": func(c *Config, value string) (err error) {
+		c.Publisher.MaxRuns, err = strconv.Atoi(value)
+		return err
+	},
+	"publisher.history_size": func(c *Config, value string) (err error) {
+		c.Publisher.HistorySize, err = strconv.Atoi(value)
+		return err
+	},
+	"publisher.ping_interval": func(c *Config, value string) (err error) {
+		c.Publisher.PingInterval, err = time.ParseDuration(value)
+		return err
+	},
+	"publisher.queue_size": func(c *Config, value string) (err error) {
+		c.Publisher.QueueSize, err = strconv.Atoi(value)
+		return err
+	},
+	"publisher.overflow_policy": func(c *Config, value string) (err error) {
+		c.Publisher.OverflowPolicy, err = ParseOverflowPolicy(value)
+		return err
+	},
+	"publisher.stale_after": func(c *Config, value string) (err error) {
+		c.Publisher.StaleAfter, err = time.ParseDuration(value)
+		return err
+	},
+	"publisher.stale_after_states": func(c *Config, value string) (err error) {
+		c.Publisher.StaleAfterStates, err = ParseStaleThresholds(value)
+		return err
+	},
+	"publisher.state_file": func(c *Config, value string) error {
+		c.Publisher.StateFile = value
+		return nil
+	},
+	"publisher.state_save_interval": func(c *Config, value string) (err error) {
+		c.Publisher.StateSaveInterval, err = time.ParseDuration(value)
+		return err
+	},
+}
+
+// LoadConfig reads the configuration file at path on top of the defaults.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("error reading config: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return config, fmt.Errorf("error parsing config: %w", err)
+	}
+	if len(root.Content) == 0 {
+		return config, nil
+	}
+
+	if err := config.setNode("", root.Content[0]); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// setNode applies a YAML mapping, using the scalars' literal text so values
+// are parsed the same way as environment variables and flags. Sequences of
+// scalars are joined with commas.
+func (c *Config) setNode(prefix string, node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", node.Line)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		value := node.Content[i+1]
+		switch value.Kind {
+		case yaml.MappingNode:
+			if err := c.setNode(key, value); err != nil {
+				return err
+			}
+		case yaml.ScalarNode:
+			if err := c.Set(key, value.Value); err != nil {
+				return fmt.Errorf("line %d: %w", value.Line, err)
+			}
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(value.Content))
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: %s: expected a value", item.Line, key)
+				}
+				items = append(items, item.Value)
+			}
+			if err := c.Set(key, strings.Join(items, ",")); err != nil {
+				return fmt.Errorf("line %d: %w", value.Line, err)
+			}
+		default:
+			return fmt.Errorf("line %d: %s: expected a value", value.Line, key)
+		}
+	}
+
+	return nil
+}
+
+// Set changes a single configuration key like mqtt.broker.
+func (c *Config) Set(key, value string) error {
+	set, ok := configKeys[key]
+	if !ok {
+		return fmt.Errorf("%s: unknown key", key)
+	}
+
+	if err := set(c, value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	return nil
+}
+
+// ConfigKeys returns all configuration keys in sorted order.
+func ConfigKeys() []string {
+	keys := make([]string, 0, len(configKeys))
+	for key := range configKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// EnvName returns the environment variable overriding a configuration key.
+func EnvName(key string) string {
+	return "BSS_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ApplyEnv overrides keys for which lookup finds an environment variable.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, key := range ConfigKeys() {
+		value, ok := lookup(EnvName(key))
+		if !ok {
+			continue
+		}
+
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("%s: %w", EnvName(key), err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the configuration for values that parse but are not
+// usable.
+func (c Config) Validate() error {
+	var errs []error
+
+	if len(c.Sources) == 0 {
+		errs = append(errs, errors.New("sources: must not be empty"))
+	}
+	for i, name := range c.Sources {
+		if !validSource(name) {
+			errs = append(errs, fmt.Errorf("sources: unknown source %q, expected one of %s", name, strings.Join(SourceNames, ", ")))
+		} else if slices.Contains(c.Sources[:i], name) {
+			errs = append(errs, fmt.Errorf("sources: source %q is listed more than once", name))
+		}
+	}
+	if u, err := url.Parse(c.MQTT.Broker); c.MQTT.Broker == "" || err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("mqtt.broker: invalid broker url %q", c.MQTT.Broker))
+	}
+	if c.MQTT.Topic == "" {
+		errs = append(errs, errors.New("mqtt.topic: must not be empty"))
+	}
+	if c.MQTT.QoS > 2 {
+		errs = append(errs, fmt.Errorf("mqtt.qos: must be 0, 1 or 2, got %d", c.MQTT.QoS))
+	}
+	if (c.MQTT.CertFile == "") != (c.MQTT.KeyFile == "") {
+		errs = append(errs, errors.New("mqtt.cert_file: must be set together with mqtt.key_file"))
+	}
+	if c.MQTT.PasswordFile != "" && c.MQTT.Username == "" {
+		errs = append(errs, errors.New("mqtt.username: must be set when mqtt.password_file is set"))
+	}
+	if c.MQTT.RecordMaxSize < 1 {
+		errs = append(errs, fmt.Errorf("mqtt.record_max_size: must be positive, got %d", c.MQTT.RecordMaxSize))
+	}
+	if c.MQTT.RecordMaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("mqtt.record_max_files: must not be negative, got %d", c.MQTT.RecordMaxFiles))
+	}
+	if c.EmbeddedBroker.Listen != "" && !slices.Contains(c.Sources, "mqtt") {
+		errs = append(errs, errors.New("embedded_broker.listen: requires the mqtt source"))
+	}
+	if slices.Contains(c.Sources, "ingest") && c.Ingest.TokenFile == "" {
+		errs = append(errs, errors.New("ingest.token_file: must be set for the ingest source"))
+	}
+	if slices.Contains(c.Sources, "relay") {
+		if u, err := url.Parse(c.Relay.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("relay.url: invalid events url %q", c.Relay.URL))
+		}
+	}
+	if c.Relay.IdleTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("relay.idle_timeout: must be positive, got %s", c.Relay.IdleTimeout))
+	}
+	if c.HTTP.Listen == "" {
+		errs = append(errs, errors.New("http.listen: must not be empty"))
+	}
+	if c.Publisher.MaxMessages < 1 {
+		errs = append(errs, fmt.Errorf("publisher.max_messages: must be positive, got %d", c.Publisher.MaxMessages))
+	}
+	if c.Publisher.MaxErrors < 1 {
+		errs = append(errs, fmt.Errorf("publisher.max_errors: must be positive, got %d", c.Publisher.MaxErrors))
+	}
+	if c.Publisher.MaxRuns < 1 {
+		errs = append(errs, fmt.Errorf("publisher.max_runs: must be positive, got %d", c.Publisher.MaxRuns))
+	}
+	if c.Publisher.HistorySize < 0 {
+		errs = append(errs, fmt.Errorf("publisher.history_size: must not be negative, got %d", c.Publisher.HistorySize))
+	}
+	if c.Publisher.PingInterval <= 0 {
+		errs = append(errs, fmt.Errorf("publisher.ping_interval: must be positive, got %s", c.Publisher.PingInterval))
+	}
+	if c.Publisher.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("publisher.queue_size: must be positive, got %d", c.Publisher.QueueSize))
+	}
+	if c.Publisher.StaleAfter < 0 {
+		errs = append(errs, fmt.Errorf("publisher.stale_after: must not be negative, got %s", c.Publisher.StaleAfter))
+	}
+	for _, state := range slices.Sorted(maps.Keys(c.Publisher.StaleAfterStates)) {
+		if d := c.Publisher.StaleAfterStates[state]; d < 0 {
+			errs = append(errs, fmt.Errorf("publisher.stale_after_states: %s must not be negative, got %s", state, d))
+		}
+	}
+	if c.Publisher.StateSaveInterval <= 0 {
+		errs = append(errs, fmt.Errorf("publisher.state_save_interval: must be positive, got %s", c.Publisher.StateSaveInterval))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options returns the publisher options for this configuration.
+func (c PublisherConfig) Options() []PublisherOption {
+	opts := []PublisherOption{
+		WithMaxMessages(c.MaxMessages),
+		WithMaxErrors(c.MaxErrors),
+		WithMaxRuns(c.MaxRuns),
+		WithHistorySize(c.HistorySize),
+		WithPingInterval(c.PingInterval),
+		WithQueueSize(c.QueueSize),
+		WithOverflowPolicy(c.OverflowPolicy),
+		WithStaleAfter(c.StaleAfter),
+		WithStateFile(c.StateFile),
+		WithStateSaveInterval(c.StateSaveInterval),
+	}
+	for state, d := range c.StaleAfterStates {
+		opts = append(opts, WithStaleAfterState(state, d))
+	}
+
+	return opts
+}
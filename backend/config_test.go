@@ -0,0 +1,206 @@
+package backend
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	assert.NoError(t, DefaultConfig().Validate())
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	config, err := LoadConfig("config.example.yaml")
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultConfig(), config)
+}
+
+func TestLoadConfig(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	config, err := LoadConfig(writeConfig(t, `
+log_level: debug
+mqtt:
+  broker: ssl://broker.example.org:8883
+  qos: 1
+relay:
+  idle_timeout: 5m
+http:
+  listen: unix:/run/bss.sock
+  socket_mode: 0600
+publisher:
+  max_messages: 5
+  max_errors: 20
+  max_runs: 5
+  ping_interval: 30s
+  overflow_policy: resync
+  stale_after: 15m
+  stale_after_states:
+    - offline=0s
+  state_file: /var/lib/build-server-status/state.json
+`))
+	require.NoError(err)
+	require.NoError(config.Validate())
+
+	assert.Equal("debug", config.LogLevel)
+	assert.Equal("ssl://broker.example.org:8883", config.MQTT.Broker)
+	assert.Equal("build/#", config.MQTT.Topic)
+	assert.Equal(byte(1), config.MQTT.QoS)
+	assert.Equal(5*time.Minute, config.Relay.IdleTimeout)
+	assert.Equal("unix:/run/bss.sock", config.HTTP.Listen)
+	assert.Equal(fs.FileMode(0o600), config.HTTP.SocketMode)
+	assert.Equal(5, config.Publisher.MaxMessages)
+	assert.Equal(20, config.Publisher.MaxErrors)
+	assert.Equal(5, config.Publisher.MaxRuns)
+	assert.Equal(30*time.Second, config.Publisher.PingInterval)
+	assert.Equal(OverflowResync, config.Publisher.OverflowPolicy)
+	assert.Equal(15*time.Minute, config.Publisher.StaleAfter)
+	assert.Equal(map[string]time.Duration{"offline": 0}, config.Publisher.StaleAfterStates)
+	assert.Equal("/var/lib/build-server-status/state.json", config.Publisher.StateFile)
+	assert.Equal(time.Minute, config.Publisher.StateSaveInterval)
+}
+
+func TestLoadConfigSourcesList(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+sources: [mqtt, relay]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"mqtt", "relay"}, config.Sources)
+}
+
+func TestConfigSetSourcesFromString(t *testing.T) {
+	config := DefaultConfig()
+
+	require.NoError(t, config.Set("sources", "mqtt, relay"))
+
+	assert.Equal(t, []string{"mqtt", "relay"}, config.Sources)
+}
+
+func TestLoadConfigNamesBadKey(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{config: "mqtt:\n  brokr: tcp://localhost:1883\n", err: "mqtt.brokr: unknown key"},
+		{config: "publisher:\n  queue_size: many\n", err: "publisher.queue_size:"},
+		{config: "publisher:\n  ping_interval: 15\n", err: "publisher.ping_interval:"},
+		{config: "publisher:\n  overflow_policy: block\n", err: "publisher.overflow_policy:"},
+		{config: "http: 8080\n", err: "http: unknown key"},
+		{config: "- listen\n", err: "expected a mapping"},
+		{config: "log_level: loud\n", err: "log_level:"},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadConfig(writeConfig(t, tt.config))
+		assert.ErrorContains(t, err, tt.err, tt.config)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+
+	assert.Error(t, err)
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	config := DefaultConfig()
+	env := map[string]string{
+		"BSS_MQTT_BROKER":          "tcp://mosquitto:1883",
+		"BSS_PUBLISHER_QUEUE_SIZE": "128",
+	}
+
+	err := config.ApplyEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "tcp://mosquitto:1883", config.MQTT.Broker)
+	assert.Equal(t, 128, config.Publisher.QueueSize)
+}
+
+func TestConfigApplyEnvNamesVariable(t *testing.T) {
+	config := DefaultConfig()
+
+	err := config.ApplyEnv(func(name string) (string, bool) {
+		return "zero", name == "BSS_MQTT_QOS"
+	})
+
+	assert.ErrorContains(t, err, "BSS_MQTT_QOS: mqtt.qos:")
+}
+
+func TestConfigValidateNamesBadKeys(t *testing.T) {
+	config := DefaultConfig()
+	config.MQTT.Broker = "msg.alpinelinux.org"
+	config.MQTT.QoS = 3
+	config.Publisher.MaxMessages = 0
+	config.Publisher.MaxErrors = -1
+	config.Publisher.MaxRuns = 0
+	config.Publisher.PingInterval = 0
+	config.Relay.IdleTimeout = 0
+	config.Publisher.StateSaveInterval = 0
+	config.Publisher.StaleAfterStates = map[string]time.Duration{"online": -time.Minute}
+	config.Sources = []string{"carrier-pigeon"}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "mqtt.broker:")
+	assert.ErrorContains(t, err, "mqtt.qos:")
+	assert.ErrorContains(t, err, "publisher.max_messages:")
+	assert.ErrorContains(t, err, "publisher.max_errors:")
+	assert.ErrorContains(t, err, "publisher.max_runs:")
+	assert.ErrorContains(t, err, "publisher.ping_interval:")
+	assert.ErrorContains(t, err, "relay.idle_timeout:")
+	assert.ErrorContains(t, err, "publisher.state_save_interval:")
+	assert.ErrorContains(t, err, "publisher.stale_after_states: online")
+	assert.ErrorContains(t, err, `sources: unknown source "carrier-pigeon"`)
+}
+
+func TestConfigValidateMQTTCredentials(t *testing.T) {
+	config := DefaultConfig()
+	config.MQTT.CertFile = "client.pem"
+	config.MQTT.PasswordFile = "password"
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "mqtt.cert_file:")
+	assert.ErrorContains(t, err, "mqtt.username:")
+}
+
+func TestConfigValidateRejectsDuplicateSources(t *testing.T) {
+	config := DefaultConfig()
+	config.Sources = []string{"mqtt", "ingest", "mqtt"}
+	config.Ingest.TokenFile = "tokens"
+
+	assert.EqualError(t, config.Validate(), `sources: source "mqtt" is listed more than once`)
+}
+
+func TestConfigValidateIngestRequiresTokens(t *testing.T) {
+	config := DefaultConfig()
+	config.Sources = []string{"ingest"}
+
+	assert.ErrorContains(t, config.Validate(), "ingest.token_file:")
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "BSS_MQTT_BROKER", EnvName("mqtt.broker"))
+	assert.Equal(t, "BSS_PUBLISHER_MAX_MESSAGES", EnvName("publisher.max_messages"))
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
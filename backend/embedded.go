@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// EmbeddedBroker is an in-process MQTT broker for test and development
+// setups. It accepts every client, so builders and the simulator can
+// publish to it without any further setup. It is run as a Source, but
+// delivers no messages itself: the MQTT source subscribes to it like to any
+// other broker.
+type EmbeddedBroker struct {
+	listener net.Listener
+}
+
+// NewEmbeddedBroker listens on addr right away, so clients can connect
+// before the broker is running and are served once it is.
+func NewEmbeddedBroker(addr string) (*EmbeddedBroker, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	return &EmbeddedBroker{
+		listener: listener,
+	}, nil
+}
+
+func (b *EmbeddedBroker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// URL returns the broker URL for connecting to the embedded broker from the
+// same host.
+func (b *EmbeddedBroker) URL() string {
+	addr := b.listener.Addr().(*net.TCPAddr)
+	if addr.IP.IsUnspecified() {
+		return fmt.Sprintf("tcp://127.0.0.1:%d", addr.Port)
+	}
+
+	return "tcp://" + addr.String()
+}
+
+func (b *EmbeddedBroker) Run(ctx context.Context, msgs chan<- Message) error {
+	// The broker warns about every client closing its connection.
+	logger := log.With().Str("component", "embedded-broker").Logger().Level(zerolog.ErrorLevel)
+	server := mochi.New(&mochi.Options{
+		Logger: slog.New(zerolog.NewSlogHandler(logger)),
+	})
+
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		b.listener.Close()
+		return fmt.Errorf("error configuring embedded broker: %w", err)
+	}
+	if err := server.AddListener(listeners.NewNet("embedded", b.listener)); err != nil {
+		b.listener.Close()
+		return fmt.Errorf("error configuring embedded broker: %w", err)
+	}
+	if err := server.Serve(); err != nil {
+		server.Close()
+		return fmt.Errorf("error starting embedded broker: %w", err)
+	}
+	log.Info().Msgf("Embedded broker listening on %s", b.listener.Addr())
+
+	<-ctx.Done()
+
+	// Give clients, including the mqtt source, the chance to unsubscribe and
+	// disconnect before closing their connections.
+	deadline := time.Now().Add(disconnectTimeout)
+	for connectedClients(server) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return server.Close()
+}
+
+func connectedClients(server *mochi.Server) int {
+	n := 0
+	for _, client := range server.Clients.GetByListener("embedded") {
+		if !client.Closed() {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunWithEmbeddedBroker(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	config := DefaultConfig()
+	config.EmbeddedBroker.Listen = "127.0.0.1:0"
+	require.NoError(config.Validate())
+
+	sources, opts, err := NewSources(config)
+	require.NoError(err)
+	require.IsType(&EmbeddedBroker{}, sources[0])
+	broker := sources[0].(*EmbeddedBroker)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	baseURL := "http://" + listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(ctx, listener, sources, opts...)
+	}()
+
+	require.Eventually(func() bool {
+		response, err := http.Get(baseURL + "/readyz")
+		if err != nil {
+			return false
+		}
+		response.Body.Close()
+		return response.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	builder := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker.URL()).SetClientID("builder"))
+	token := builder.Connect()
+	require.True(token.WaitTimeout(5 * time.Second))
+	require.NoError(token.Error())
+
+	token = builder.Publish("build/build-edge-x86_64", 0, false, "1/2 3/10 busybox 1.36.1-r0")
+	require.True(token.WaitTimeout(5 * time.Second))
+	require.NoError(token.Error())
+
+	var builders []struct {
+		Builder      string
+		LastProgress *BuildStatusMessage
+	}
+	require.Eventually(func() bool {
+		response, err := http.Get(baseURL + "/api/builders")
+		if err != nil {
+			return false
+		}
+		defer response.Body.Close()
+		return json.NewDecoder(response.Body).Decode(&builders) == nil && len(builders) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal("build-edge-x86_64", builders[0].Builder)
+	assert.Equal("busybox", builders[0].LastProgress.PackageName)
+
+	builder.Disconnect(0)
+	cancel()
+	select {
+	case err := <-errCh:
+		require.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+}
+
+func TestEmbeddedBrokerURL(t *testing.T) {
+	broker, err := NewEmbeddedBroker("0.0.0.0:0")
+	require.NoError(t, err)
+	defer broker.listener.Close()
+
+	assert.Regexp(t, `^tcp://127\.0\.0\.1:[0-9]+$`, broker.URL())
+}
+
+func TestEmbeddedBrokerRequiresMQTTSource(t *testing.T) {
+	config := DefaultConfig()
+	config.Sources = nil
+	config.EmbeddedBroker.Listen = "127.0.0.1:1883"
+
+	assert.ErrorContains(t, config.Validate(), "embedded_broker.listen: requires the mqtt source")
+}
@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EventReader reads the event stream served on /events, turning every
+// event back into the message it carries.
+type EventReader struct {
+	reader *bufio.Reader
+
+	// Fields of the event being read.
+	data    []string
+	eventID string
+	retry   time.Duration
+}
+
+func NewEventReader(r io.Reader) *EventReader {
+	return &EventReader{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next event or, for comments like keepalives and the one
+// announcing a snapshot, its text. Events that cannot be decoded are logged
+// and skipped. The retry delay requested by the server is returned with the
+// following event.
+func (r *EventReader) Next() (Event, string, error) {
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return Event{}, "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(r.data) == 0 {
+				continue
+			}
+
+			event, err := r.decode(r.eventID, strings.Join(r.data, "\n"))
+			r.data, r.eventID = nil, ""
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to decode event")
+				continue
+			}
+
+			return event, "", nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			return Event{}, value, nil
+		case "id":
+			r.eventID = value
+		case "data":
+			r.data = append(r.data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+func (r *EventReader) decode(eventID, data string) (Event, error) {
+	msg, err := UnmarshalMessage([]byte(data))
+	if err != nil {
+		return Event{}, err
+	}
+
+	// Events without a valid ID cannot be resumed from and keep ID 0.
+	id, _ := strconv.ParseUint(eventID, 10, 64)
+	event := Event{ID: id, Msg: msg, Retry: r.retry, data: []byte(data)}
+	r.retry = 0
+
+	return event, nil
+}
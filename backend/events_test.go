@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventReaderReadsEventsAndComments(t *testing.T) {
+	stream := ": connected\n\n" +
+		": snapshot\n\n" +
+		"id: 41\ndata: {\"MsgType\":\"state\",\"Msg\":\"online\",\"Builder\":\"BuilderA\",\"State\":\"online\"}\n\n" +
+		"id: 42\r\ndata: {\"MsgType\":\"msg\",\r\ndata: \"Msg\":\"pulling git\",\"Builder\":\"BuilderA\"}\r\n\r\n" +
+		"retry: 5000\nid: 43\ndata: {\"MsgType\":\"system\",\"Status\":\"server-shutting-down\"}\n\n"
+	reader := NewEventReader(strings.NewReader(stream))
+
+	_, comment, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "connected", comment)
+
+	_, comment, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "snapshot", comment)
+
+	event, comment, err := reader.Next()
+	require.NoError(t, err)
+	assert.Empty(t, comment)
+	assert.Equal(t, uint64(41), event.ID)
+	assert.Equal(t, MessageFromString("build/BuilderA/state", "online"), event.Msg)
+
+	event, _, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), event.ID)
+	assert.Equal(t, MessageFromString("build/BuilderA", "pulling git"), event.Msg)
+	assert.Zero(t, event.Retry)
+
+	event, _, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(43), event.ID)
+	assert.Equal(t, "server-shutting-down", event.Msg.(SystemMessage).Status)
+	assert.Equal(t, 5*time.Second, event.Retry)
+
+	_, _, err = reader.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestEventReaderSkipsUndecodableEvents(t *testing.T) {
+	stream := "id: 1\ndata: {\"MsgType\":\"bogus\"}\n\n" +
+		"data: {\"MsgType\":\"idle\",\"Msg\":\"idle\",\"Builder\":\"BuilderA\"}\n\n"
+	reader := NewEventReader(strings.NewReader(stream))
+
+	event, _, err := reader.Next()
+	require.NoError(t, err)
+	assert.Zero(t, event.ID, "events without an ID")
+	assert.IsType(t, IdleMessage{}, event.Msg)
+}
+
+func TestEventReaderReportsTruncatedStream(t *testing.T) {
+	reader := NewEventReader(strings.NewReader("id: 1\ndata: {\"MsgType\""))
+
+	_, _, err := reader.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestEventReaderReadsPublishedStream(t *testing.T) {
+	msgs := []Message{
+		MessageFromString("build/BuilderA", "1/2 3/10 busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"busybox"}`),
+	}
+
+	recorder := httptest.NewRecorder()
+	conn := &sseConnection{writer: recorder, flusher: recorder}
+	for i, msg := range msgs {
+		require.NoError(t, conn.WriteEvent(newEvent(uint64(i+1), msg)))
+	}
+	require.NoError(t, conn.WriteComment("ping"))
+
+	reader := NewEventReader(recorder.Body)
+	for i, msg := range msgs {
+		event, _, err := reader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), event.ID)
+		assert.Equal(t, msg, event.Msg)
+	}
+	_, comment, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "ping", comment)
+}
@@ -0,0 +1,195 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultFollowerMinBackoff = time.Second
+	defaultFollowerMaxBackoff = time.Minute
+	// defaultFollowerIdleTimeout drops connections that stopped sending even
+	// the keepalive comments of an instance with the default ping interval.
+	defaultFollowerIdleTimeout = 4 * defaultPingInterval
+)
+
+// StreamHandler receives what a StreamFollower reads from the stream. Its
+// methods are called from the goroutine running the follower.
+type StreamHandler interface {
+	// Connected is called once a connection is established.
+	Connected(ctx context.Context)
+	// Disconnected is called when an established connection is lost.
+	Disconnected(ctx context.Context, err error)
+	// Comment is called for comments like keepalives and SnapshotComment.
+	Comment(ctx context.Context, text string)
+	// Event is called for every event carrying a message.
+	Event(ctx context.Context, event Event)
+}
+
+// StreamFollower follows the /events stream of an instance. It reconnects
+// with exponential backoff, honouring the retry delay requested by the
+// instance, and resumes from the last received event.
+type StreamFollower struct {
+	url     string
+	client  *http.Client
+	handler StreamHandler
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	idleTimeout time.Duration
+
+	lastEventID uint64
+	resume      bool
+	// retry is the reconnect delay last requested by the instance.
+	retry time.Duration
+}
+
+type FollowerOption func(*StreamFollower)
+
+// WithFollowerHTTPClient sets the HTTP client used to connect to the
+// instance.
+func WithFollowerHTTPClient(client *http.Client) FollowerOption {
+	return func(f *StreamFollower) {
+		f.client = client
+	}
+}
+
+// WithFollowerBackoff sets the range of the delay between reconnects.
+func WithFollowerBackoff(min, max time.Duration) FollowerOption {
+	return func(f *StreamFollower) {
+		f.minBackoff = min
+		f.maxBackoff = max
+	}
+}
+
+// WithFollowerIdleTimeout sets how long the stream may stay silent before
+// the connection is considered lost. It has to be longer than the ping
+// interval of the instance.
+func WithFollowerIdleTimeout(timeout time.Duration) FollowerOption {
+	return func(f *StreamFollower) {
+		f.idleTimeout = timeout
+	}
+}
+
+// NewStreamFollower creates a follower for the event stream at url, passing
+// what it reads to handler.
+func NewStreamFollower(url string, handler StreamHandler, opts ...FollowerOption) *StreamFollower {
+	f := &StreamFollower{
+		url:         url,
+		client:      &http.Client{},
+		handler:     handler,
+		minBackoff:  defaultFollowerMinBackoff,
+		maxBackoff:  defaultFollowerMaxBackoff,
+		idleTimeout: defaultFollowerIdleTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// Run follows the stream until ctx is cancelled. Connection failures are
+// retried, so Run only returns once ctx is done.
+func (f *StreamFollower) Run(ctx context.Context) {
+	backoff := f.minBackoff
+
+	for {
+		connected, err := f.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if connected {
+			backoff = f.minBackoff
+			f.handler.Disconnected(ctx, err)
+		}
+
+		delay := backoff
+		if f.retry > 0 {
+			delay, f.retry = f.retry, 0
+		} else {
+			backoff = min(2*backoff, f.maxBackoff)
+		}
+
+		log.Warn().Err(err).Msgf("Connection to %s failed, reconnecting in %s", f.url, delay)
+		if sleep(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// stream follows a single connection until it fails and reports whether the
+// connection was established.
+func (f *StreamFollower) stream(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+	if f.resume {
+		request.Header.Set("Last-Event-ID", strconv.FormatUint(f.lastEventID, 10))
+	}
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		return false, fmt.Errorf("unexpected content type %q", response.Header.Get("Content-Type"))
+	}
+
+	log.Debug().Msgf("Connected to %s", f.url)
+	f.handler.Connected(ctx)
+
+	idle := time.AfterFunc(f.idleTimeout, func() {
+		cancel(fmt.Errorf("no data received for %s", f.idleTimeout))
+	})
+	defer idle.Stop()
+
+	events := NewEventReader(response.Body)
+	for {
+		event, comment, err := events.Next()
+		if err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				return true, cause
+			}
+			if errors.Is(err, io.EOF) {
+				return true, errors.New("server closed the stream")
+			}
+			return true, err
+		}
+		idle.Reset(f.idleTimeout)
+
+		if event.Msg == nil {
+			f.handler.Comment(ctx, comment)
+			continue
+		}
+
+		if event.ID != 0 {
+			f.lastEventID = event.ID
+			f.resume = true
+		}
+		if event.Retry > 0 {
+			f.retry = event.Retry
+		}
+		f.handler.Event(ctx, event)
+	}
+}
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingStream records the calls of a StreamFollower as strings.
+type recordingStream struct {
+	calls chan string
+}
+
+func (s *recordingStream) Connected(ctx context.Context) {
+	s.calls <- "connected"
+}
+
+func (s *recordingStream) Disconnected(ctx context.Context, err error) {
+	s.calls <- "disconnected: " + err.Error()
+}
+
+func (s *recordingStream) Comment(ctx context.Context, text string) {
+	s.calls <- "comment: " + text
+}
+
+func (s *recordingStream) Event(ctx context.Context, event Event) {
+	s.calls <- fmt.Sprintf("event %d: %s", event.ID, event.Msg.Get())
+}
+
+func TestStreamFollowerPassesStreamToHandler(t *testing.T) {
+	var requests atomic.Int32
+	lastEventIDs := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		switch requests.Add(1) {
+		case 1:
+			fmt.Fprint(w, ": connected\n\n: snapshot\n\n")
+			fmt.Fprint(w, "id: 3\ndata: {\"MsgType\":\"msg\",\"Msg\":\"pulling git\",\"Builder\":\"BuilderA\"}\n\n")
+		default:
+			fmt.Fprint(w, ": connected\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	stream := &recordingStream{calls: make(chan string, 10)}
+	follower := NewStreamFollower(server.URL, stream, WithFollowerBackoff(time.Millisecond, time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go follower.Run(ctx)
+
+	var got []string
+	for range 7 {
+		select {
+		case call := <-stream.calls:
+			got = append(got, call)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("missing calls, got %v", got)
+		}
+	}
+
+	assert.Equal(t, []string{
+		"connected",
+		"comment: connected",
+		"comment: snapshot",
+		"event 3: BuilderA: pulling git",
+		"disconnected: server closed the stream",
+		"connected",
+		"comment: connected",
+	}, got)
+	assert.Equal(t, "", <-lastEventIDs)
+	assert.Equal(t, "3", <-lastEventIDs)
+}
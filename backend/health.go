@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const livenessTimeout = 2 * time.Second
+
+// BrokerStatus tracks the connection to the MQTT broker for the readiness
+// probe. It is updated from the MQTT client's connection handlers.
+type BrokerStatus struct {
+	mu         sync.Mutex
+	connected  bool
+	subscribed bool
+	since      time.Time
+	lastError  error
+}
+
+func NewBrokerStatus() *BrokerStatus {
+	return &BrokerStatus{
+		since: time.Now(),
+	}
+}
+
+func (s *BrokerStatus) Connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.connected {
+		s.connected = true
+		s.since = time.Now()
+	}
+	s.lastError = nil
+}
+
+// Disconnected also drops the subscription, as the broker may not have kept
+// the session until the client reconnects.
+func (s *BrokerStatus) Disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connected {
+		s.connected = false
+		s.since = time.Now()
+	}
+	s.subscribed = false
+	s.lastError = err
+}
+
+// Subscribed marks the build topics as subscribed on the current connection.
+func (s *BrokerStatus) Subscribed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscribed = true
+}
+
+// BrokerReport is the body of the readiness probe.
+type BrokerReport struct {
+	Ready      bool
+	Connected  bool
+	Subscribed bool
+	Since      time.Time
+	Duration   string
+	Error      string `json:",omitempty"`
+}
+
+func (s *BrokerStatus) Report() BrokerReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := BrokerReport{
+		Ready:      s.connected && s.subscribed,
+		Connected:  s.connected,
+		Subscribed: s.subscribed,
+		Since:      s.since,
+		Duration:   time.Since(s.since).Round(time.Second).String(),
+	}
+	if s.lastError != nil {
+		report.Error = s.lastError.Error()
+	}
+
+	return report
+}
+
+// WithBrokerStatus makes the readiness probe depend on the broker connection.
+// With several sources, the instance is ready once all of them are.
+func WithBrokerStatus(status *BrokerStatus) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.brokers = append(b.brokers, status)
+	}
+}
+
+// alive checks that the publisher loop is still processing requests.
+func (b *BuildStatusPublisher) alive(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, livenessTimeout)
+	defer cancel()
+
+	reply := make(chan struct{}, 1)
+	select {
+	case b.probeCh <- reply:
+	case <-ctx.Done():
+		return false
+	}
+
+	select {
+	case <-reply:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *BuildStatusPublisher) healthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !b.alive(r.Context()) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"Status": "publisher not responding"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"Status": "ok"})
+	}
+}
+
+func (b *BuildStatusPublisher) readyzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(b.brokers) == 0 {
+			writeJSON(w, http.StatusOK, BrokerReport{Ready: true})
+			return
+		}
+
+		for _, broker := range b.brokers {
+			if report := broker.Report(); !report.Ready {
+				writeJSON(w, http.StatusServiceUnavailable, report)
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, b.brokers[0].Report())
+	}
+}
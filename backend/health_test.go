@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerStatusReadiness(t *testing.T) {
+	assert := assert.New(t)
+
+	status := NewBrokerStatus()
+	assert.False(status.Report().Ready)
+
+	status.Connected()
+	assert.False(status.Report().Ready, "not ready before subscribing")
+
+	status.Subscribed()
+	report := status.Report()
+	assert.True(report.Ready)
+	assert.True(report.Connected)
+	assert.Empty(report.Error)
+
+	status.Disconnected(errors.New("connection reset"))
+	report = status.Report()
+	assert.False(report.Ready)
+	assert.False(report.Connected)
+	assert.Equal("connection reset", report.Error)
+
+	status.Connected()
+	assert.False(status.Report().Ready, "not ready before subscribing again")
+	status.Subscribed()
+	assert.True(status.Report().Ready)
+}
+
+func TestBrokerStatusKeepsSinceWhileStateIsUnchanged(t *testing.T) {
+	status := NewBrokerStatus()
+	status.Connected()
+	since := status.Report().Since
+
+	status.Connected()
+
+	assert.Equal(t, since, status.Report().Since)
+}
+
+func TestHealthzReportsRunningPublisher(t *testing.T) {
+	publisher, _, cancel := createPublisher(t)
+	defer cancel()
+
+	recorder := requestAPI(t, publisher, "/healthz")
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"Status":"ok"}`, recorder.Body.String())
+}
+
+func TestHealthzReportsStalledPublisher(t *testing.T) {
+	publisher := NewBuildStatusPublisher(make(chan Message, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/healthz", nil).WithContext(ctx)
+	publisher.handler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestReadyzFollowsBrokerStatus(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	status := NewBrokerStatus()
+	publisher := NewBuildStatusPublisher(make(chan Message, 1), WithBrokerStatus(status))
+
+	recorder := httptest.NewRecorder()
+	publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+
+	status.Connected()
+	status.Subscribed()
+
+	recorder = httptest.NewRecorder()
+	publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(http.StatusOK, recorder.Code)
+
+	var report BrokerReport
+	require.NoError(json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.True(report.Ready)
+	assert.True(report.Connected)
+	assert.True(report.Subscribed)
+	assert.NotEmpty(report.Duration)
+}
+
+func TestReadyzWaitsForAllSources(t *testing.T) {
+	mqtt, relay := NewBrokerStatus(), NewBrokerStatus()
+	publisher := NewBuildStatusPublisher(make(chan Message, 1), WithBrokerStatus(mqtt), WithBrokerStatus(relay))
+
+	mqtt.Connected()
+	mqtt.Subscribed()
+
+	recorder := httptest.NewRecorder()
+	publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	relay.Connected()
+	relay.Subscribed()
+
+	recorder = httptest.NewRecorder()
+	publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,53 @@
+package backend
+
+const defaultHistorySize = 1024
+
+// eventRing keeps the most recent broadcast events so reconnecting clients
+// can be sent only what they missed.
+type eventRing struct {
+	events []Event
+	start  int
+	size   int
+}
+
+func newEventRing(capacity int) *eventRing {
+	return &eventRing{
+		events: make([]Event, capacity),
+	}
+}
+
+func (r *eventRing) add(e Event) {
+	if len(r.events) == 0 {
+		return
+	}
+
+	if r.size < len(r.events) {
+		r.events[(r.start+r.size)%len(r.events)] = e
+		r.size++
+		return
+	}
+
+	r.events[r.start] = e
+	r.start = (r.start + 1) % len(r.events)
+}
+
+func (r *eventRing) at(i int) Event {
+	return r.events[(r.start+i)%len(r.events)]
+}
+
+// since returns all events following the event with the given ID. It returns
+// false if events directly following that ID have already been dropped.
+func (r *eventRing) since(id uint64) ([]Event, bool) {
+	if r.size == 0 || r.at(0).ID > id+1 {
+		return nil, false
+	}
+
+	events := []Event{}
+	for i := 0; i < r.size; i++ {
+		if e := r.at(i); e.ID > id {
+			events = append(events, e)
+		}
+	}
+
+	return events, true
+}
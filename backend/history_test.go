@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventRingReturnsEventsSinceID(t *testing.T) {
+	ring := newEventRing(3)
+	for id := uint64(1); id <= 5; id++ {
+		ring.add(Event{ID: id})
+	}
+
+	tests := []struct {
+		id  uint64
+		ids []uint64
+		ok  bool
+	}{
+		{id: 1, ok: false},
+		{id: 2, ids: []uint64{3, 4, 5}, ok: true},
+		{id: 4, ids: []uint64{5}, ok: true},
+		{id: 5, ids: []uint64{}, ok: true},
+	}
+
+	for _, tt := range tests {
+		events, ok := ring.since(tt.id)
+		assert.Equal(t, tt.ok, ok, tt.id)
+		if !tt.ok {
+			continue
+		}
+
+		ids := []uint64{}
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		assert.Equal(t, tt.ids, ids, tt.id)
+	}
+}
+
+func TestEventRingEmpty(t *testing.T) {
+	_, ok := newEventRing(3).since(0)
+
+	assert.False(t, ok)
+}
@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+const maxIngestPayload = 64 << 10
+
+// IngestResult is the response of the ingest endpoint. Status is accepted,
+// ignored or rejected, Reason explains why a message was not accepted.
+type IngestResult struct {
+	Status string
+	Type   string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
+// IngestSource accepts messages pushed over HTTP by builders that cannot
+// use MQTT. A request to /api/ingest/{builder}/{subtopic} is handled like a
+// message on build/{builder}/{subtopic}, and requests are authenticated
+// with a bearer token.
+type IngestSource struct {
+	tokens   [][]byte
+	incoming chan Message
+	done     chan struct{}
+}
+
+// NewIngestSource reads the accepted tokens from tokenFile, one per line.
+// Empty lines and lines starting with # are skipped.
+func NewIngestSource(tokenFile string) (*IngestSource, error) {
+	file, err := os.Open(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token file: %w", err)
+	}
+	defer file.Close()
+
+	s := &IngestSource{
+		incoming: make(chan Message),
+		done:     make(chan struct{}),
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if token == "" || strings.HasPrefix(token, "#") {
+			continue
+		}
+		s.tokens = append(s.tokens, []byte(token))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading token file: %w", err)
+	}
+	if len(s.tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found in %s", tokenFile)
+	}
+
+	return s, nil
+}
+
+// Options returns the publisher options serving the ingest endpoint.
+func (s *IngestSource) Options() []PublisherOption {
+	return []PublisherOption{
+		WithHandler("POST /api/ingest/{builder}", s),
+		WithHandler("POST /api/ingest/{builder}/{subtopic}", s),
+	}
+}
+
+func (s *IngestSource) Run(ctx context.Context, msgs chan<- Message) error {
+	defer close(s.done)
+
+	for {
+		select {
+		case msg := <-s.incoming:
+			if !deliver(ctx, msgs, msg) {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *IngestSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+		writeJSON(w, http.StatusUnauthorized, IngestResult{Status: "rejected", Reason: "invalid or missing token"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestPayload))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, IngestResult{Status: "rejected", Reason: "payload too large"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, IngestResult{Status: "rejected", Reason: "error reading payload"})
+		return
+	}
+
+	payload := strings.TrimRight(string(body), "\r\n")
+	if !utf8.ValidString(payload) {
+		writeJSON(w, http.StatusBadRequest, IngestResult{Status: "rejected", Reason: "payload is not valid UTF-8"})
+		return
+	}
+
+	topic := "build/" + r.PathValue("builder")
+	subtopic := r.PathValue("subtopic")
+	if subtopic != "" {
+		topic += "/" + subtopic
+	}
+	if subtopic == "errors" && payload != "" && !json.Valid([]byte(payload)) {
+		writeJSON(w, http.StatusBadRequest, IngestResult{Status: "rejected", Reason: "errors payload is not valid JSON"})
+		return
+	}
+
+	msg := MessageFromString(topic, payload)
+	if msg == nil {
+		unknownSubtopics.Inc()
+		writeJSON(w, http.StatusOK, IngestResult{Status: "ignored", Reason: "unknown subtopic"})
+		return
+	}
+
+	select {
+	case s.incoming <- Stamp(msg, time.Now(), OriginIngest):
+	case <-s.done:
+		writeJSON(w, http.StatusServiceUnavailable, IngestResult{Status: "rejected", Reason: "shutting down"})
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	log.Debug().Str("topic", topic).Msg("Ingested message")
+	writeJSON(w, http.StatusAccepted, IngestResult{Status: "accepted", Type: msg.Type()})
+}
+
+func (s *IngestSource) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	valid := false
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), t) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
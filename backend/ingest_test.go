@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startIngest(t *testing.T) (http.Handler, chan Message) {
+	t.Helper()
+
+	source, err := NewIngestSource(writeFile(t, "tokens", "# CI jobs\nsecret\n\nother\n"))
+	require.NoError(t, err)
+
+	msgs := make(chan Message, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go source.Run(ctx, msgs)
+
+	return NewBuildStatusPublisher(make(chan Message), source.Options()...).handler(), msgs
+}
+
+func ingest(t *testing.T, handler http.Handler, path, token, payload string) (*httptest.ResponseRecorder, IngestResult) {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(payload))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	var result IngestResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+
+	return recorder, result
+}
+
+func TestIngestAcceptsMessages(t *testing.T) {
+	tests := []struct {
+		path    string
+		payload string
+		msgType string
+	}{
+		{path: "/api/ingest/ci-x86_64", payload: "1/2 3/10 busybox 1.36.1-r0\n", msgType: "progress"},
+		{path: "/api/ingest/ci-x86_64", payload: "pulling git", msgType: "msg"},
+		{path: "/api/ingest/ci-x86_64/state", payload: "online", msgType: "state"},
+		{path: "/api/ingest/ci-x86_64/errors", payload: `{"reponame":"main","pkgname":"busybox"}`, msgType: "error"},
+	}
+
+	handler, msgs := startIngest(t)
+	for _, tt := range tests {
+		recorder, result := ingest(t, handler, tt.path, "other", tt.payload)
+
+		assert.Equal(t, http.StatusAccepted, recorder.Code, tt.path)
+		assert.Equal(t, IngestResult{Status: "accepted", Type: tt.msgType}, result, tt.path)
+
+		select {
+		case msg := <-msgs:
+			assert.Equal(t, "ci-x86_64", msg.BuilderName())
+			assert.Equal(t, tt.msgType, msg.Type())
+		case <-time.After(time.Second):
+			t.Fatalf("%s: message not delivered", tt.path)
+		}
+	}
+}
+
+func TestIngestStripsTrailingNewline(t *testing.T) {
+	handler, msgs := startIngest(t)
+
+	ingest(t, handler, "/api/ingest/ci-x86_64/state", "secret", "online\r\n")
+
+	msg := (<-msgs).(BuildStateMessage)
+	assert.Equal(t, "online", msg.State)
+	assert.Equal(t, OriginIngest, msg.Origin)
+}
+
+func TestIngestIgnoresUnknownSubtopic(t *testing.T) {
+	handler, msgs := startIngest(t)
+
+	recorder, result := ingest(t, handler, "/api/ingest/ci-x86_64/unknown", "secret", "ignored")
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "ignored", result.Status)
+	assert.Empty(t, msgs)
+}
+
+func TestIngestRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		payload string
+		code    int
+		reason  string
+	}{
+		{name: "no token", path: "/api/ingest/ci-x86_64", payload: "idle", code: http.StatusUnauthorized, reason: "invalid or missing token"},
+		{name: "wrong token", path: "/api/ingest/ci-x86_64", token: "guess", payload: "idle", code: http.StatusUnauthorized, reason: "invalid or missing token"},
+		{name: "comment as token", path: "/api/ingest/ci-x86_64", token: "# CI jobs", payload: "idle", code: http.StatusUnauthorized, reason: "invalid or missing token"},
+		{name: "invalid errors", path: "/api/ingest/ci-x86_64/errors", token: "secret", payload: "{", code: http.StatusBadRequest, reason: "errors payload is not valid JSON"},
+		{name: "binary", path: "/api/ingest/ci-x86_64", token: "secret", payload: "\xff", code: http.StatusBadRequest, reason: "payload is not valid UTF-8"},
+		{name: "too large", path: "/api/ingest/ci-x86_64", token: "secret", payload: strings.Repeat("x", maxIngestPayload+1), code: http.StatusRequestEntityTooLarge, reason: "payload too large"},
+	}
+
+	handler, msgs := startIngest(t)
+	for _, tt := range tests {
+		recorder, result := ingest(t, handler, tt.path, tt.token, tt.payload)
+
+		assert.Equal(t, tt.code, recorder.Code, tt.name)
+		assert.Equal(t, IngestResult{Status: "rejected", Reason: tt.reason}, result, tt.name)
+	}
+	assert.Empty(t, msgs)
+}
+
+func TestIngestRejectsAfterShutdown(t *testing.T) {
+	source, err := NewIngestSource(writeFile(t, "tokens", "secret\n"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, source.Run(ctx, make(chan Message)))
+
+	handler := NewBuildStatusPublisher(make(chan Message), source.Options()...).handler()
+	recorder, result := ingest(t, handler, "/api/ingest/ci-x86_64", "secret", "idle")
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "shutting down", result.Reason)
+}
+
+func TestNewIngestSourceRequiresTokens(t *testing.T) {
+	_, err := NewIngestSource(writeFile(t, "tokens", "# nothing yet\n"))
+
+	assert.ErrorContains(t, err, "no tokens found")
+}
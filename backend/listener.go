@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed by systemd socket activation, see
+// sd_listen_fds(3).
+const listenFdsStart = 3
+
+// Listen creates the HTTP listener for addr, which is one of:
+//
+//   - host:port to listen on TCP
+//   - unix:/path to listen on a unix socket created with socketMode
+//   - systemd to use the socket passed by systemd socket activation
+func Listen(addr string, socketMode fs.FileMode) (net.Listener, error) {
+	switch {
+	case addr == "systemd":
+		return systemdListener()
+	case strings.HasPrefix(addr, "unix:"):
+		return unixListener(strings.TrimPrefix(addr, "unix:"), socketMode)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+func unixListener(path string, mode fs.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("missing unix socket path")
+	}
+
+	// Remove a stale socket left behind by a previous instance.
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error setting socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd")
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("no sockets passed by systemd")
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("expected one socket from systemd, got %d", fds)
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("error using systemd socket: %w", err)
+	}
+
+	return listener, nil
+}
+
+// ParseSocketMode parses an octal file mode like 0660.
+func ParseSocketMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode: %s", s)
+	}
+
+	return fs.FileMode(mode), nil
+}
@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenTCP(t *testing.T) {
+	listener, err := Listen("127.0.0.1:0", 0)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	assert.Equal(t, "tcp", listener.Addr().Network())
+}
+
+func TestListenUnixSocketSetsPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bss.sock")
+
+	listener, err := Listen("unix:"+path, 0o600)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeSocket, info.Mode().Type())
+	assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestListenUnixSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bss.sock")
+
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	listener, err := Listen("unix:"+path, 0o660)
+	require.NoError(t, err)
+	listener.Close()
+}
+
+func TestListenUnixSocketRequiresPath(t *testing.T) {
+	_, err := Listen("unix:", 0o660)
+
+	assert.Error(t, err)
+}
+
+func TestServeHTTPOnUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bss.sock")
+	listener, err := Listen("unix:"+path, 0o600)
+	require.NoError(t, err)
+
+	publisher := NewBuildStatusPublisher(make(chan Message, 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.ListenHTTP(ctx, listener)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	response, err := client.Get("http://unix/healthz")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestListenSystemdUsesInheritedSocket(t *testing.T) {
+	if os.Getenv("BSS_SYSTEMD_HELPER") == "1" {
+		// systemd sets LISTEN_PID after forking, which is not possible here.
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listener, err := Listen("systemd", 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(listener.Addr())
+		os.Exit(0)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer file.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenSystemdUsesInheritedSocket$")
+	cmd.Env = append(os.Environ(), "BSS_SYSTEMD_HELPER=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{file}
+	output, err := cmd.Output()
+	require.NoError(t, err)
+
+	assert.Equal(t, listener.Addr().String(), string(output))
+}
+
+func TestListenSystemdRequiresMatchingPid(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	_, err := Listen("systemd", 0)
+
+	assert.Error(t, err)
+}
+
+func TestListenSystemdRequiresSingleSocket(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+
+	_, err := Listen("systemd", 0)
+
+	assert.ErrorContains(t, err, "got 2")
+}
+
+func TestParseSocketMode(t *testing.T) {
+	mode, err := ParseSocketMode("0660")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o660), mode)
+
+	for _, invalid := range []string{"", "rw", "0999", "1777"} {
+		_, err := ParseSocketMode(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
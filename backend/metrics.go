@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsNamespace = "build_server_status"
+
+var unknownSubtopics = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "mqtt_unknown_subtopics_total",
+	Help:      "Number of MQTT messages dropped because of an unknown build subtopic.",
+})
+
+var (
+	builderBuildProgressDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "build_progress"),
+		"Number of packages built in the current repository.",
+		[]string{"builder"}, nil,
+	)
+	builderBuildProgressMaxDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "build_progress_max"),
+		"Number of packages to build in the current repository.",
+		[]string{"builder"}, nil,
+	)
+	builderTotalProgressDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "total_progress"),
+		"Number of packages processed in the current repository.",
+		[]string{"builder"}, nil,
+	)
+	builderTotalProgressMaxDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "total_progress_max"),
+		"Number of packages in the current repository.",
+		[]string{"builder"}, nil,
+	)
+	builderStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "state"),
+		"State reported by the builder, always 1.",
+		[]string{"builder", "state"}, nil,
+	)
+	builderErrorDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "error"),
+		"Whether the builder currently reports an error.",
+		[]string{"builder"}, nil,
+	)
+	builderStaleDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "stale"),
+		"Whether the builder has been silent for longer than the stale threshold.",
+		[]string{"builder"}, nil,
+	)
+	builderLastSeenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "builder", "last_seen_timestamp_seconds"),
+		"Time of the last message from the builder.",
+		[]string{"builder"}, nil,
+	)
+)
+
+// publisherMetrics are updated from the publisher loop.
+type publisherMetrics struct {
+	messages      *prometheus.CounterVec
+	subscribers   prometheus.Gauge
+	mqttConnected prometheus.Gauge
+}
+
+func newPublisherMetrics() *publisherMetrics {
+	return &publisherMetrics{
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "messages_total",
+			Help:      "Number of messages received by the publisher by type.",
+		}, []string{"type"}),
+		subscribers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "subscribers",
+			Help:      "Number of connected subscribers.",
+		}),
+		mqttConnected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "mqtt_connected",
+			Help:      "Whether the connection to the MQTT broker is up.",
+		}),
+	}
+}
+
+// observe updates the metrics derived from an incoming message.
+func (m *publisherMetrics) observe(msg Message) {
+	m.messages.WithLabelValues(msg.Type()).Inc()
+
+	if msg, ok := msg.(SystemMessage); ok {
+		switch msg.Status {
+		case "mqtt-connected":
+			m.mqttConnected.Set(1)
+		case "mqtt-disconnected":
+			m.mqttConnected.Set(0)
+		}
+	}
+}
+
+// builderCollector exports the current state of each builder, read from the
+// publisher loop on every scrape.
+type builderCollector struct {
+	publisher *BuildStatusPublisher
+}
+
+func (c builderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- builderBuildProgressDesc
+	ch <- builderBuildProgressMaxDesc
+	ch <- builderTotalProgressDesc
+	ch <- builderTotalProgressMaxDesc
+	ch <- builderStateDesc
+	ch <- builderErrorDesc
+	ch <- builderStaleDesc
+	ch <- builderLastSeenDesc
+}
+
+func (c builderCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	snapshot, err := c.publisher.snapshot(ctx, "")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to collect builder metrics")
+		return
+	}
+
+	for _, builder := range snapshot {
+		var progress BuildStatusMessage
+		if builder.LastProgress != nil {
+			progress = *builder.LastProgress
+		}
+
+		ch <- prometheus.MustNewConstMetric(builderBuildProgressDesc, prometheus.GaugeValue, float64(progress.BuildProgress.Current), builder.Builder)
+		ch <- prometheus.MustNewConstMetric(builderBuildProgressMaxDesc, prometheus.GaugeValue, float64(progress.BuildProgress.Total), builder.Builder)
+		ch <- prometheus.MustNewConstMetric(builderTotalProgressDesc, prometheus.GaugeValue, float64(progress.TotalProgress.Current), builder.Builder)
+		ch <- prometheus.MustNewConstMetric(builderTotalProgressMaxDesc, prometheus.GaugeValue, float64(progress.TotalProgress.Total), builder.Builder)
+
+		if builder.State != "" {
+			ch <- prometheus.MustNewConstMetric(builderStateDesc, prometheus.GaugeValue, 1, builder.Builder, builder.State)
+		}
+
+		hasError := 0.0
+		if builder.Error != nil {
+			hasError = 1
+		}
+		ch <- prometheus.MustNewConstMetric(builderErrorDesc, prometheus.GaugeValue, hasError, builder.Builder)
+
+		stale := 0.0
+		if builder.Stale {
+			stale = 1
+		}
+		ch <- prometheus.MustNewConstMetric(builderStaleDesc, prometheus.GaugeValue, stale, builder.Builder)
+		if !builder.LastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(builderLastSeenDesc, prometheus.GaugeValue, float64(builder.LastSeen.UnixNano())/1e9, builder.Builder)
+		}
+	}
+}
+
+func (b *BuildStatusPublisher) metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		unknownSubtopics,
+		b.metrics.messages,
+		b.metrics.subscribers,
+		b.metrics.mqttConnected,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "subscribers_dropped_total",
+			Help:      "Number of subscribers disconnected because their send queue overflowed.",
+		}, func() float64 {
+			return float64(b.DroppedSubscribers())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "subscribers_resynced_total",
+			Help:      "Number of times an overflowing subscriber queue was replaced with a snapshot.",
+		}, func() float64 {
+			return float64(b.ResyncedSubscribers())
+		}),
+		builderCollector{publisher: b},
+	)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsExposesBuilderAndPublisherState(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	for _, msg := range []Message{
+		NewSystemMessage("mqtt-connected", "Connected to broker"),
+		MessageFromString("build/BuilderA/state", "online"),
+		MessageFromString("build/BuilderA", "2/5 10/20 main/packageA 1.0.0-r0"),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"packageA","hostname":"BuilderA"}`),
+		MessageFromString("build/BuilderB", "pulling git"),
+	} {
+		channels.msg <- msg
+		publisher.makeStep()
+	}
+
+	recorder := requestAPI(t, publisher, "/metrics")
+	require.Equal(http.StatusOK, recorder.Code)
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`build_server_status_builder_build_progress{builder="BuilderA"} 2`,
+		`build_server_status_builder_build_progress_max{builder="BuilderA"} 5`,
+		`build_server_status_builder_total_progress{builder="BuilderA"} 10`,
+		`build_server_status_builder_total_progress_max{builder="BuilderA"} 20`,
+		`build_server_status_builder_state{builder="BuilderA",state="online"} 1`,
+		`build_server_status_builder_error{builder="BuilderA"} 1`,
+		`build_server_status_builder_error{builder="BuilderB"} 0`,
+		`build_server_status_builder_stale{builder="BuilderA"} 0`,
+		`build_server_status_builder_last_seen_timestamp_seconds{builder="BuilderA"} 1.741944413e+09`,
+		`build_server_status_builder_build_progress{builder="BuilderB"} 0`,
+		`build_server_status_messages_total{type="progress"} 1`,
+		`build_server_status_messages_total{type="msg"} 1`,
+		`build_server_status_messages_total{type="system"} 1`,
+		`build_server_status_subscribers 1`,
+		`build_server_status_mqtt_connected 1`,
+	} {
+		assert.Contains(body, line+"\n")
+	}
+	assert.NotContains(body, `builder=""`)
+}
+
+func TestMetricsTracksMQTTDisconnect(t *testing.T) {
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	channels.msg <- NewSystemMessage("mqtt-connected", "Connected to broker")
+	publisher.makeStep()
+	channels.msg <- NewSystemMessage("mqtt-disconnected", "Connection to broker lost")
+	publisher.makeStep()
+	// The connection to an upstream instance is not the broker's.
+	channels.msg <- NewSystemMessage("relay-connected", "Connected to upstream")
+	publisher.makeStep()
+
+	recorder := requestAPI(t, publisher, "/metrics")
+	assert.Contains(t, recorder.Body.String(), "build_server_status_mqtt_connected 0\n")
+}
+
+func TestMetricsCountsUnknownSubtopics(t *testing.T) {
+	before := testutil.ToFloat64(unknownSubtopics)
+
+	handler := MessageHandler(t.Context(), make(chan Message, 1), nil, time.Now)
+	handler(nil, mockMQTTMessage{topic: "build/BuilderA/unknown", payload: "ignored"})
+	handler(nil, mockMQTTMessage{topic: "build/BuilderA/state", payload: "online"})
+
+	assert.Equal(t, before+1, testutil.ToFloat64(unknownSubtopics))
+}
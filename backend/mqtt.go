@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog/log"
@@ -40,14 +41,32 @@ func ProgressFromString(s string) Progress {
 type Message interface {
 	Get() string
 	BuilderName() string
+	Type() string
 }
 
 type GenericMessage struct {
 	MsgType string
 	Msg     string
 	Builder string
+	// Received is when the message reached this or, for relayed messages,
+	// the upstream instance.
+	Received time.Time `json:",omitzero"`
+	Origin   Origin    `json:",omitempty"`
 }
 
+// Origin tells where a message came from.
+type Origin string
+
+const (
+	OriginMQTT      Origin = "mqtt"
+	OriginIngest    Origin = "ingest"
+	OriginReplay    Origin = "replay"
+	OriginSimulator Origin = "simulator"
+	// OriginSystem marks messages about the server itself, and the ones the
+	// publisher derives from the messages it received.
+	OriginSystem Origin = "system"
+)
+
 func MessageFromString(topic, msg string) Message {
 	builder, subtype := parseBuildTopic(topic)
 	if builder == "" {
@@ -126,6 +145,16 @@ func (m GenericMessage) BuilderName() string {
 	return m.Builder
 }
 
+func (m GenericMessage) Type() string {
+	return m.MsgType
+}
+
+// ReceivedAt returns the time the message was received, zero if it was
+// never stamped.
+func (m GenericMessage) ReceivedAt() time.Time {
+	return m.Received
+}
+
 type BuildStatusMessage struct {
 	GenericMessage
 	BuildProgress  Progress
@@ -159,6 +188,54 @@ type RemovedMessage struct {
 	GenericMessage
 }
 
+// StaleMessage is sent by the publisher when a builder has been silent for
+// longer than the configured threshold, and with Stale unset once it is
+// heard from again.
+type StaleMessage struct {
+	GenericMessage
+	Stale    bool
+	LastSeen time.Time
+}
+
+// RestoredMessage is sent by the publisher for builders whose state was
+// restored from the state file, and with Restored unset once new messages
+// confirm it.
+type RestoredMessage struct {
+	GenericMessage
+	Restored bool
+	Saved    time.Time
+}
+
+// ErrorHistoryMessage carries the recent build errors of a builder, oldest
+// first. The publisher sends it to new subscribers before the current error.
+type ErrorHistoryMessage struct {
+	GenericMessage
+	Errors []BuildErrorMessage
+}
+
+// AppendError adds err to the error history errs, dropping the oldest
+// errors beyond max. An error already at the end of the history, as sent
+// again after an ErrorHistoryMessage, is not added twice.
+func AppendError(errs []BuildErrorMessage, err BuildErrorMessage, max int) []BuildErrorMessage {
+	if n := len(errs); n > 0 && sameMessage(errs[n-1], err) && errs[n-1].Received.Equal(err.Received) {
+		return errs
+	}
+
+	errs = append(errs, err)
+	if len(errs) > max {
+		errs = errs[len(errs)-max:]
+	}
+
+	return errs
+}
+
+// SnapshotMessage starts a snapshot on the event stream. The events that
+// follow replace the state a client has, as browsers do not pass the
+// SnapshotComment on.
+type SnapshotMessage struct {
+	GenericMessage
+}
+
 type SystemMessage struct {
 	GenericMessage
 	Status string
@@ -167,26 +244,152 @@ type SystemMessage struct {
 func NewSystemMessage(status, msg string) SystemMessage {
 	return SystemMessage{
 		GenericMessage: GenericMessage{
-			MsgType: "system",
-			Msg:     msg,
+			MsgType:  "system",
+			Msg:      msg,
+			Received: time.Now(),
+			Origin:   OriginSystem,
 		},
 		Status: status,
 	}
 }
 
-func MessageHandler(ctx context.Context, msgs chan Message) mqtt.MessageHandler {
+// Stamp returns msg marked as received at the given time from origin.
+func Stamp(msg Message, received time.Time, origin Origin) Message {
+	stamp := func(m *GenericMessage) {
+		m.Received = received
+		m.Origin = origin
+	}
+
+	switch m := msg.(type) {
+	case GenericMessage:
+		stamp(&m)
+		return m
+	case BuildStatusMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case BuildErrorMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case IdleMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case BuildStateMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case RemovedMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case StaleMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case RestoredMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case ErrorHistoryMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case RunStartedMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case RunEndedMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case SnapshotMessage:
+		stamp(&m.GenericMessage)
+		return m
+	case SystemMessage:
+		stamp(&m.GenericMessage)
+		return m
+	}
+
+	return msg
+}
+
+// sameMessage reports whether a and b are equal apart from when and from
+// where they were received.
+func sameMessage(a, b Message) bool {
+	return Stamp(a, time.Time{}, "") == Stamp(b, time.Time{}, "")
+}
+
+// UnmarshalMessage decodes the JSON encoding of a message, as sent on the
+// event stream, into the message type named by its MsgType.
+func UnmarshalMessage(data []byte) (Message, error) {
+	var header struct {
+		MsgType string
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	switch header.MsgType {
+	case "msg":
+		return unmarshalMessage[GenericMessage](data)
+	case "progress":
+		return unmarshalMessage[BuildStatusMessage](data)
+	case "error":
+		return unmarshalMessage[BuildErrorMessage](data)
+	case "state":
+		return unmarshalMessage[BuildStateMessage](data)
+	case "idle":
+		return unmarshalMessage[IdleMessage](data)
+	case "removed":
+		return unmarshalMessage[RemovedMessage](data)
+	case "stale":
+		return unmarshalMessage[StaleMessage](data)
+	case "restored":
+		return unmarshalMessage[RestoredMessage](data)
+	case "errors":
+		return unmarshalMessage[ErrorHistoryMessage](data)
+	case "run-started":
+		return unmarshalMessage[RunStartedMessage](data)
+	case "run-ended":
+		return unmarshalMessage[RunEndedMessage](data)
+	case "snapshot":
+		return unmarshalMessage[SnapshotMessage](data)
+	case "system":
+		return unmarshalMessage[SystemMessage](data)
+	default:
+		return nil, fmt.Errorf("unknown message type %q", header.MsgType)
+	}
+}
+
+func unmarshalMessage[T Message](data []byte) (Message, error) {
+	var m T
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// MessageHandler parses received messages into msgs, stamped with the time
+// now returns. If recorder is not nil, the raw traffic is appended to its
+// capture file as well.
+func MessageHandler(ctx context.Context, msgs chan<- Message, recorder *Recorder, now func() time.Time) mqtt.MessageHandler {
 	return func(c mqtt.Client, m mqtt.Message) {
 		log.Debug().
 			Str("topic", m.Topic()).
 			Str("payload", string(m.Payload())).
 			Msg("Received message from broker")
+		received := now()
+		if recorder != nil {
+			record := CaptureRecord{
+				Time:    received,
+				Topic:   m.Topic(),
+				Payload: string(m.Payload()),
+			}
+			if err := recorder.Record(record); err != nil {
+				log.Error().Err(err).Msg("Failed to record message")
+			}
+		}
 		msg := MessageFromString(m.Topic(), string(m.Payload()))
 		if msg == nil {
 			log.Debug().
 				Str("topic", m.Topic()).
 				Msg("Ignoring unknown build subtopic")
+			unknownSubtopics.Inc()
 			return
 		}
-		msgs <- msg
+		deliver(ctx, msgs, Stamp(msg, received, OriginMQTT))
 	}
 }
@@ -1,7 +1,11 @@
 package backend
 
 import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,6 +19,30 @@ func TestNewSystemMessage(t *testing.T) {
 	assert.Equal(t, "Connected to broker", msg.Msg)
 	assert.Equal(t, "", msg.BuilderName())
 	assert.Equal(t, ": Connected to broker", msg.Get())
+	assert.Equal(t, OriginSystem, msg.Origin)
+	assert.False(t, msg.Received.IsZero())
+}
+
+func TestStampKeepsMessageType(t *testing.T) {
+	received := time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+	msg := Stamp(MessageFromString("build/BuilderA", "1/2 3/10 busybox 1.36.1-r0"), received, OriginReplay)
+
+	require.IsType(t, BuildStatusMessage{}, msg)
+	assert.Equal(t, received, msg.(BuildStatusMessage).Received)
+	assert.Equal(t, OriginReplay, msg.(BuildStatusMessage).Origin)
+	assert.Equal(t, "busybox", msg.(BuildStatusMessage).PackageName)
+}
+
+func TestStampedMessageJSON(t *testing.T) {
+	received := time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+
+	data, err := json.Marshal(Stamp(MessageFromString("build/BuilderA", "pulling git"), received, OriginMQTT))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"MsgType":"msg","Msg":"pulling git","Builder":"BuilderA","Received":"2025-03-14T09:26:53Z","Origin":"mqtt"}`, string(data))
+
+	data, err = json.Marshal(MessageFromString("build/BuilderA", "pulling git"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"MsgType":"msg","Msg":"pulling git","Builder":"BuilderA"}`, string(data), "unstamped")
 }
 
 func TestParseBuildTopic(t *testing.T) {
@@ -65,3 +93,142 @@ func TestMessageFromStringIgnoresBuildTopicWithoutBuilder(t *testing.T) {
 
 	assert.Nil(t, msg)
 }
+
+func TestUnmarshalMessageRoundTrips(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{name: "msg", msg: MessageFromString("build/BuilderA", "pulling git")},
+		{name: "progress", msg: MessageFromString("build/BuilderA", "1/2 3/10 busybox 1.36.1-r0")},
+		{name: "error", msg: MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"busybox","logurl":"https://build.alpinelinux.org/log","hostname":"BuilderA"}`)},
+		{name: "state", msg: MessageFromString("build/BuilderA/state", "online")},
+		{name: "idle", msg: MessageFromString("build/BuilderA", "idle")},
+		{name: "removed", msg: RemovedMessage{GenericMessage: GenericMessage{MsgType: "removed", Builder: "BuilderA"}}},
+		{name: "stale", msg: StaleMessage{GenericMessage: GenericMessage{MsgType: "stale", Msg: "Silent", Builder: "BuilderA"}, Stale: true, LastSeen: time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)}},
+		{name: "restored", msg: RestoredMessage{GenericMessage: GenericMessage{MsgType: "restored", Msg: "Restored", Builder: "BuilderA"}, Restored: true, Saved: time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)}},
+		{name: "errors", msg: ErrorHistoryMessage{GenericMessage: GenericMessage{MsgType: "errors", Msg: "1 recent errors", Builder: "BuilderA"}, Errors: []BuildErrorMessage{
+			Stamp(MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"busybox"}`), time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC), OriginMQTT).(BuildErrorMessage),
+		}}},
+		{name: "run-started", msg: RunStartedMessage{GenericMessage: GenericMessage{MsgType: "run-started", Msg: "Started", Builder: "BuilderA"}, Run: BuildRun{
+			Builder:  "BuilderA",
+			Started:  time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC),
+			Total:    10,
+			Packages: []RunPackage{{Name: "main/busybox", Version: "1.36.1-r0", Started: time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)}},
+		}}},
+		{name: "run-ended", msg: RunEndedMessage{GenericMessage: GenericMessage{MsgType: "run-ended", Msg: "Ended", Builder: "BuilderA"}, Run: BuildRun{
+			Builder: "BuilderA",
+			Started: time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC),
+			Ended:   time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC),
+			Total:   10,
+			End:     RunEndIdle,
+		}}},
+		{name: "system", msg: NewSystemMessage("mqtt-connected", "Connected to broker")},
+	}
+
+	received := time.Date(2025, 3, 14, 9, 26, 53, 589793000, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.name, tt.msg.Type())
+			tt.msg = Stamp(tt.msg, received, OriginMQTT)
+			data, err := json.Marshal(tt.msg)
+			require.NoError(t, err)
+
+			msg, err := UnmarshalMessage(data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.msg, msg)
+
+			remarshalled, err := json.Marshal(msg)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(data), string(remarshalled))
+		})
+	}
+}
+
+func TestUnmarshalMessageRejectsInvalidData(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{data: `{"MsgType":"bogus"}`, err: `unknown message type "bogus"`},
+		{data: `{"Builder":"BuilderA"}`, err: `unknown message type ""`},
+		{data: `{"MsgType":"progress","BuildProgress":"1/2"}`, err: "cannot unmarshal"},
+		{data: `not json`, err: "invalid character"},
+	}
+
+	for _, tt := range tests {
+		_, err := UnmarshalMessage([]byte(tt.data))
+		assert.ErrorContains(t, err, tt.err, tt.data)
+	}
+}
+
+func TestMessageHandlerRecordsTraffic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	recorder, err := NewRecorder(path, 1<<20, 1)
+	require.NoError(t, err)
+
+	msgs := make(chan Message, 1)
+	handler := MessageHandler(t.Context(), msgs, recorder, func() time.Time { return testNow })
+	handler(nil, mockMQTTMessage{topic: "build/BuilderA", payload: "pulling git"})
+	handler(nil, mockMQTTMessage{topic: "build/BuilderA/unknown", payload: "ignored"})
+	require.NoError(t, recorder.Close())
+
+	msg := (<-msgs).(GenericMessage)
+	assert.Equal(t, "BuilderA: pulling git", msg.Get())
+	assert.Equal(t, OriginMQTT, msg.Origin)
+	assert.Equal(t, testNow, msg.Received)
+	assert.Equal(t, []string{"pulling git", "ignored"}, capturedPayloads(t, path))
+}
+
+type mockMQTTMessage struct {
+	topic   string
+	payload string
+}
+
+func (m mockMQTTMessage) Duplicate() bool {
+	return false
+}
+
+func (m mockMQTTMessage) Qos() byte {
+	return 0
+}
+
+func (m mockMQTTMessage) Retained() bool {
+	return false
+}
+
+func (m mockMQTTMessage) Topic() string {
+	return m.topic
+}
+
+func (m mockMQTTMessage) MessageID() uint16 {
+	return 0
+}
+
+func (m mockMQTTMessage) Payload() []byte {
+	return []byte(m.payload)
+}
+
+func (m mockMQTTMessage) Ack() {}
+
+func TestAppendErrorKeepsRecentErrors(t *testing.T) {
+	buildError := func(pkgname string, received time.Time) BuildErrorMessage {
+		msg := MessageFromString("build/BuilderA/errors", fmt.Sprintf(`{"reponame":"main","pkgname":%q}`, pkgname))
+		return Stamp(msg, received, OriginMQTT).(BuildErrorMessage)
+	}
+
+	var errs []BuildErrorMessage
+	for i, pkgname := range []string{"busybox", "musl", "openssl"} {
+		errs = AppendError(errs, buildError(pkgname, testNow.Add(time.Duration(i)*time.Minute)), 2)
+	}
+	require.Len(t, errs, 2)
+	assert.Equal(t, "musl", errs[0].Pkgname)
+	assert.Equal(t, "openssl", errs[1].Pkgname)
+
+	errs = AppendError(errs, buildError("openssl", testNow.Add(2*time.Minute).In(time.FixedZone("CET", 3600))), 2)
+	assert.Len(t, errs, 2, "the same error is not added twice")
+	assert.Equal(t, "musl", errs[0].Pkgname)
+
+	errs = AppendError(errs, buildError("openssl", testNow.Add(3*time.Minute)), 2)
+	assert.Equal(t, "openssl", errs[0].Pkgname, "the package failed again")
+}
@@ -3,14 +3,19 @@ package backend
 import (
 	"context"
 	"net"
+	"net/http"
 	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// testNow is the time of the clock the publishers under test run on.
+var testNow = time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+
 type publisherChannels struct {
 	msg  chan Message
 	sent chan Message
@@ -28,9 +33,10 @@ func TestPublisherForwardsSingleMessage(t *testing.T) {
 	channels.msg <- MessageFromString("build/message-builder", "this is a message")
 	publisher.makeStep()
 
+	messages := drainMessages(channels.sent)
+
 	cancel()
 
-	messages := drainMessages(channels.sent)
 	require.Len(messages, 1)
 	assert.Equal("message-builder: this is a message", messages[0].Get())
 	assert.Equal("message-builder", messages[0].BuilderName())
@@ -71,6 +77,9 @@ func TestPublisherSendsLastThreeMessagesPerBuilder(t *testing.T) {
 
 	receivedMessagesPerBuilder := map[string][]Message{}
 	for _, msg := range drainMessages(channels.sent) {
+		if _, ok := msg.(RunStartedMessage); ok {
+			continue
+		}
 		receivedMessagesPerBuilder[msg.BuilderName()] = append(receivedMessagesPerBuilder[msg.BuilderName()], msg)
 	}
 
@@ -87,6 +96,30 @@ func TestPublisherSendsLastThreeMessagesPerBuilder(t *testing.T) {
 	}
 }
 
+func TestPublisherKeepsConfiguredNumberOfMessages(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t, WithMaxMessages(2))
+
+	for _, msg := range []Message{
+		MessageFromString("build/BuilderA", "pulling git"),
+		MessageFromString("build/BuilderA", "upgrading system"),
+		MessageFromString("build/BuilderA", "uploading packages to community"),
+	} {
+		channels.msg <- msg
+		publisher.makeStep()
+	}
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	msgs := drainMessages(channels.sent)
+	cancel()
+
+	require.Len(msgs, 2)
+	require.Equal("BuilderA: upgrading system", msgs[0].Get())
+}
+
 func TestPublisherSendsError(t *testing.T) {
 	require := require.New(t)
 	assert := assert.New(t)
@@ -103,8 +136,9 @@ func TestPublisherSendsError(t *testing.T) {
 
 	cancel()
 
-	require.Len(msgs, 1)
-	assert.IsType(BuildErrorMessage{}, msgs[0])
+	require.Len(msgs, 2)
+	assert.IsType(ErrorHistoryMessage{}, msgs[0])
+	assert.IsType(BuildErrorMessage{}, msgs[1])
 }
 
 func TestPublisherSendsEmptyError(t *testing.T) {
@@ -151,7 +185,7 @@ func TestPublisherSendsErrorMessageWhenThreeMessagesReceived(t *testing.T) {
 
 	cancel()
 
-	require.Len(msgs, 4)
+	require.Len(msgs, 5)
 	var errMsg *BuildErrorMessage
 	for _, msg := range msgs {
 		if m, ok := msg.(BuildErrorMessage); ok {
@@ -188,7 +222,74 @@ func TestPublisherResetsBuilderStateAfterIdle(t *testing.T) {
 
 	cancel()
 
-	require.Lenf(msgs, 1, "Expected only an idle message, received %d messages", len(msgs))
+	require.Lenf(msgs, 2, "Expected only an idle message and the error history, received %d messages", len(msgs))
+	require.IsType(IdleMessage{}, msgs[0])
+	require.IsType(ErrorHistoryMessage{}, msgs[1])
+}
+
+func TestPublisherKeepsErrorHistory(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t, WithMaxErrors(2))
+	defer cancel()
+
+	for _, msg := range []Message{
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"busybox"}`),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"musl"}`),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"community","pkgname":"packageA","logurl":"https://build.alpinelinux.org/log"}`),
+		MessageFromString("build/BuilderA", "idle"),
+	} {
+		channels.msg <- msg
+		publisher.makeStep()
+	}
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	msgs := drainMessages(channels.sent)
+	require.Len(msgs, 2, "the idle builder has no current error")
+	require.IsType(ErrorHistoryMessage{}, msgs[1])
+	history := msgs[1].(ErrorHistoryMessage)
+	assert.Equal("BuilderA", history.Builder)
+	assert.Equal(testNow, history.Received)
+	require.Len(history.Errors, 2)
+	assert.Equal("musl", history.Errors[0].Pkgname)
+	assert.Equal("packageA", history.Errors[1].Pkgname)
+	assert.Equal("https://build.alpinelinux.org/log", history.Errors[1].Logurl)
+
+	snapshot := publisher.buildSnapshot("BuilderA")
+	assert.Nil(snapshot[0].Error)
+	assert.Equal(history.Errors, snapshot[0].Errors)
+}
+
+func TestPublisherReplacesRelayedErrorHistory(t *testing.T) {
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	received := testNow.Add(-time.Hour)
+	current := Stamp(MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"musl"}`), received, OriginMQTT).(BuildErrorMessage)
+
+	channels.msg <- MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"openssl"}`)
+	publisher.makeStep()
+	// A snapshot of another instance sends the history before the current
+	// error.
+	channels.msg <- ErrorHistoryMessage{
+		GenericMessage: GenericMessage{MsgType: "errors", Builder: "BuilderA"},
+		Errors: []BuildErrorMessage{
+			Stamp(MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"busybox"}`), received.Add(-time.Minute), OriginMQTT).(BuildErrorMessage),
+			current,
+		},
+	}
+	publisher.makeStep()
+	channels.msg <- current
+	publisher.makeStep()
+
+	snapshot := publisher.buildSnapshot("BuilderA")
+	require.Len(t, snapshot[0].Errors, 2)
+	assert.Equal(t, "busybox", snapshot[0].Errors[0].Pkgname)
+	assert.Equal(t, current, snapshot[0].Errors[1])
+	assert.Equal(t, current, snapshot[0].Error)
 }
 
 func TestPublisherDoesNotRemoveBuilderWhenStateStillExists(t *testing.T) {
@@ -248,9 +349,38 @@ func TestPublisherRemovesBuilderWhenStateIsCleared(t *testing.T) {
 	cancel()
 
 	require.Len(msgs, 1)
-	require.IsType(RemovedMessage{}, msgs[0])
-	require.Equal("removed", msgs[0].(RemovedMessage).MsgType)
-	require.Equal("BuilderA", msgs[0].BuilderName())
+	require.Equal(RemovedMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "removed",
+			Builder:  "BuilderA",
+			Received: testNow,
+			Origin:   OriginSystem,
+		},
+	}, msgs[0])
+}
+
+func TestPublisherIgnoresRepeatedMessagesReceivedLater(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	for i := range 2 {
+		received := testNow.Add(time.Duration(i) * time.Minute)
+		channels.msg <- Stamp(MessageFromString("build/BuilderA/state", "online"), received, OriginMQTT)
+		publisher.makeStep()
+		channels.msg <- Stamp(MessageFromString("build/BuilderA", "pulling git"), received, OriginMQTT)
+		publisher.makeStep()
+	}
+
+	msgs := drainMessages(channels.sent)
+	cancel()
+
+	require.Len(msgs, 2)
+	require.Equal(testNow, msgs[0].(BuildStateMessage).Received)
+	require.Equal(testNow, msgs[1].(GenericMessage).Received)
 }
 
 func TestPublisherDoesNotRemoveBuilderWhenOnlyErrorIsCleared(t *testing.T) {
@@ -279,7 +409,194 @@ func TestPublisherDoesNotRemoveBuilderWhenOnlyErrorIsCleared(t *testing.T) {
 	assert.Equal("BuilderA: ", msgs[0].Get())
 }
 
-func createPublisher(t *testing.T) (*BuildStatusPublisher, *publisherChannels, context.CancelFunc) {
+func TestPublisherAssignsIncreasingEventIDs(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	ids := make(chan uint64, 32)
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent, ids: ids}
+	publisher.makeStep()
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+	channels.msg <- MessageFromString("build/BuilderA", "upgrading system")
+	publisher.makeStep()
+
+	require.Len(drainMessages(channels.sent), 2)
+	first, second := <-ids, <-ids
+	cancel()
+
+	require.Equal(first+1, second)
+}
+
+func TestPublisherResumesAfterLastEventID(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	ids := make(chan uint64, 32)
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent, ids: ids}
+	publisher.makeStep()
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+	drainMessages(channels.sent)
+	lastEventID := <-ids
+
+	channels.msg <- MessageFromString("build/BuilderA", "upgrading system")
+	publisher.makeStep()
+	channels.msg <- MessageFromString("build/BuilderB", "pulling git")
+	publisher.makeStep()
+	drainMessages(channels.sent)
+	<-ids
+	<-ids
+
+	resumed := make(chan Message, 32)
+	publisher.connChan <- mockSubscriber{sent: resumed, lastEventID: lastEventID, resume: true}
+	publisher.makeStep()
+
+	msgs := drainMessages(resumed)
+	cancel()
+
+	require.Len(msgs, 2)
+	assert.Equal("BuilderA: upgrading system", msgs[0].Get())
+	assert.Equal("BuilderB: pulling git", msgs[1].Get())
+}
+
+func TestPublisherSendsNothingWhenResumingUpToDate(t *testing.T) {
+	publisher, channels, cancel := createPublisher(t)
+	ids := make(chan uint64, 32)
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent, ids: ids}
+	publisher.makeStep()
+	drainMessages(channels.sent)
+
+	resumed := make(chan Message, 32)
+	publisher.connChan <- mockSubscriber{sent: resumed, lastEventID: <-ids, resume: true}
+	publisher.makeStep()
+
+	msgs := drainMessages(resumed)
+	cancel()
+
+	require.Empty(t, msgs)
+}
+
+func TestPublisherSendsSnapshotWhenResumeGapIsUnavailable(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	publisher.history = newEventRing(2)
+
+	for _, msg := range []Message{
+		MessageFromString("build/BuilderA", "pulling git"),
+		MessageFromString("build/BuilderA", "upgrading system"),
+		MessageFromString("build/BuilderA", "uploading packages to community"),
+		MessageFromString("build/BuilderB", "pulling git"),
+	} {
+		channels.msg <- msg
+		publisher.makeStep()
+	}
+
+	resumed := make(chan Message, 32)
+	publisher.connChan <- mockSubscriber{sent: resumed, lastEventID: publisher.lastEventID - 3, resume: true, snapshots: true}
+	publisher.makeStep()
+
+	msgs := drainMessages(resumed)
+	cancel()
+
+	require.Len(msgs, 5)
+	require.IsType(SnapshotMessage{}, msgs[0], "browsers are told to replace their state")
+}
+
+func TestPublisherEncodesEventOnce(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	events := make(chan Event, 32)
+
+	publisher.connChan <- eventSubscriber{addr: "192.0.2.1:12345", events: events}
+	publisher.makeStep()
+	publisher.connChan <- eventSubscriber{addr: "192.0.2.2:12345", events: events}
+	publisher.makeStep()
+	// The snapshots of the empty state.
+	<-events
+	<-events
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+	cancel()
+
+	first, second := <-events, <-events
+	require.NotEmpty(first.data)
+	require.Same(&first.data[0], &second.data[0])
+}
+
+func TestPublisherSendsShutdownEventWithRetry(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, _, cancel := createPublisher(t)
+	events := make(chan Event, 32)
+
+	publisher.connChan <- eventSubscriber{addr: "192.0.2.1:12345", events: events}
+	publisher.makeStep()
+
+	require.IsType(SnapshotMessage{}, (<-events).Msg)
+	cancel()
+
+	var event Event
+	select {
+	case event = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("no shutdown event received")
+	}
+
+	require.IsType(SystemMessage{}, event.Msg)
+	assert.Equal("server-shutting-down", event.Msg.(SystemMessage).Status)
+	assert.Equal(testNow, event.Msg.(SystemMessage).Received)
+	assert.Equal(shutdownRetry, event.Retry)
+}
+
+func BenchmarkBroadcastEncodePerSubscriber(b *testing.B) {
+	msg := MessageFromString("build/BuilderA", "1/2 1/3 main/packageA 1.0.0-r0")
+	benchmarkBroadcast(b, 1000, func() Event {
+		return Event{ID: 1, Msg: msg}
+	})
+}
+
+func BenchmarkBroadcastEncodeOnce(b *testing.B) {
+	msg := MessageFromString("build/BuilderA", "1/2 1/3 main/packageA 1.0.0-r0")
+	benchmarkBroadcast(b, 1000, func() Event {
+		return newEvent(1, msg)
+	})
+}
+
+func benchmarkBroadcast(b *testing.B, subscribers int, event func() Event) {
+	conns := make([]*sseConnection, subscribers)
+	for n := range conns {
+		conns[n] = &sseConnection{
+			writer:  discardResponseWriter{},
+			flusher: discardResponseWriter{},
+		}
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		e := event()
+		for _, conn := range conns {
+			if err := conn.WriteEvent(e); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func createPublisher(t *testing.T, opts ...PublisherOption) (*BuildStatusPublisher, *publisherChannels, context.CancelFunc) {
 	t.Helper()
 
 	zerolog.SetGlobalLevel(zerolog.FatalLevel)
@@ -288,7 +605,8 @@ func createPublisher(t *testing.T) (*BuildStatusPublisher, *publisherChannels, c
 		sent: make(chan Message, 32),
 	}
 
-	publisher := NewBuildStatusPublisher(channels.msg)
+	clock := WithClock(func() time.Time { return testNow })
+	publisher := NewBuildStatusPublisher(channels.msg, append([]PublisherOption{clock}, opts...)...)
 	publisher.stepChan = make(chan struct{})
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -301,7 +619,10 @@ func drainMessages(c chan Message) (msgs []Message) {
 loop:
 	for {
 		select {
-		case msg := <-c:
+		case msg, ok := <-c:
+			if !ok {
+				break loop
+			}
 			msgs = append(msgs, msg)
 		default:
 			break loop
@@ -312,14 +633,30 @@ loop:
 }
 
 type mockSubscriber struct {
-	sent chan Message
+	sent        chan Message
+	ids         chan uint64
+	lastEventID uint64
+	resume      bool
+	// snapshots passes SnapshotMessages on like other messages, they are
+	// skipped like the SnapshotComment otherwise.
+	snapshots bool
 }
 
-func (c mockSubscriber) WriteJSON(v any) error {
-	c.sent <- v.(Message)
+func (c mockSubscriber) WriteEvent(e Event) error {
+	if _, ok := e.Msg.(SnapshotMessage); ok && !c.snapshots {
+		return nil
+	}
+	if c.ids != nil {
+		c.ids <- e.ID
+	}
+	c.sent <- e.Msg
 	return nil
 }
 
+func (c mockSubscriber) LastEventID() (uint64, bool) {
+	return c.lastEventID, c.resume
+}
+
 func (c mockSubscriber) WriteComment(text string) error {
 	return nil
 }
@@ -332,3 +669,40 @@ func (c mockSubscriber) Close() error {
 	close(c.sent)
 	return nil
 }
+
+// eventSubscriber records the events it receives including their encoding.
+type eventSubscriber struct {
+	addr   string
+	events chan Event
+}
+
+func (c eventSubscriber) WriteEvent(e Event) error {
+	c.events <- e
+	return nil
+}
+
+func (c eventSubscriber) WriteComment(text string) error {
+	return nil
+}
+
+func (c eventSubscriber) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(c.addr))
+}
+
+func (c eventSubscriber) Close() error {
+	return nil
+}
+
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (discardResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardResponseWriter) WriteHeader(int) {}
+
+func (discardResponseWriter) Flush() {}
@@ -0,0 +1,178 @@
+package backend
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RelaySource follows the /events stream of another instance and feeds its
+// messages to the local publisher, so edge instances can fan out a central
+// one without access to the broker. The connection to the upstream instance
+// is reported like the connection to a broker, with statuses of its own.
+type RelaySource struct {
+	url    string
+	opts   []FollowerOption
+	status *BrokerStatus
+	now    func() time.Time
+}
+
+type RelayOption func(*RelaySource)
+
+// WithRelayFollowerOptions sets the options of the follower connecting to
+// the upstream instance.
+func WithRelayFollowerOptions(opts ...FollowerOption) RelayOption {
+	return func(r *RelaySource) {
+		r.opts = append(r.opts, opts...)
+	}
+}
+
+// WithRelayClock sets the clock used to timestamp the connection state
+// changes and the removals of builders missing from a snapshot.
+func WithRelayClock(now func() time.Time) RelayOption {
+	return func(r *RelaySource) {
+		r.now = now
+	}
+}
+
+func NewRelaySource(url string, opts ...RelayOption) *RelaySource {
+	r := &RelaySource{
+		url:    url,
+		status: NewBrokerStatus(),
+		now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Status returns the upstream connection state for the readiness probe.
+func (r *RelaySource) Status() *BrokerStatus {
+	return r.status
+}
+
+func (r *RelaySource) Run(ctx context.Context, msgs chan<- Message) error {
+	stream := &relayStream{
+		relay:    r,
+		msgs:     msgs,
+		mirrored: map[string]bool{},
+	}
+	NewStreamFollower(r.url, stream, r.opts...).Run(ctx)
+
+	return nil
+}
+
+// relayStream passes the events of the upstream instance on to the local
+// publisher.
+type relayStream struct {
+	relay *RelaySource
+	msgs  chan<- Message
+
+	// mirrored are the builders relayed from upstream.
+	mirrored map[string]bool
+	// snapshot collects the builders of a snapshot being received, it is
+	// nil outside of one. All events of a snapshot share the ID in
+	// snapshotID, the snapshot ends with the next comment or event with
+	// another ID.
+	snapshot   map[string]bool
+	snapshotID *uint64
+}
+
+func (s *relayStream) Connected(ctx context.Context) {
+	log.Info().Msgf("Relaying events from %s", s.relay.url)
+	s.relay.status.Connected()
+	s.relay.status.Subscribed()
+	deliver(ctx, s.msgs, Stamp(NewSystemMessage("relay-connected", "Connected to upstream"), s.relay.now(), OriginSystem))
+}
+
+func (s *relayStream) Disconnected(ctx context.Context, err error) {
+	// A snapshot cut short tells nothing about the builders it misses.
+	s.snapshot = nil
+	s.relay.status.Disconnected(err)
+	deliver(ctx, s.msgs, Stamp(NewSystemMessage("relay-disconnected", "Connection to upstream lost"), s.relay.now(), OriginSystem))
+}
+
+func (s *relayStream) Comment(ctx context.Context, text string) {
+	if text == SnapshotComment {
+		s.startSnapshot(nil)
+		return
+	}
+
+	s.endSnapshot(ctx)
+}
+
+func (s *relayStream) Event(ctx context.Context, event Event) {
+	// The local publisher sends its own snapshots.
+	if _, ok := event.Msg.(SnapshotMessage); ok {
+		s.startSnapshot(&event.ID)
+		return
+	}
+
+	if s.snapshot != nil {
+		if s.snapshotID != nil && *s.snapshotID != event.ID {
+			s.endSnapshot(ctx)
+		} else {
+			s.snapshotID = &event.ID
+		}
+	}
+
+	if name := event.Msg.BuilderName(); name != "" {
+		if _, ok := event.Msg.(RemovedMessage); ok {
+			delete(s.mirrored, name)
+		} else {
+			s.mirrored[name] = true
+			if s.snapshot != nil {
+				s.snapshot[name] = true
+			}
+		}
+	}
+
+	// The upstream shutting down says nothing about this instance, the lost
+	// connection is reported once it drops.
+	if m, ok := event.Msg.(SystemMessage); ok && m.Status == "server-shutting-down" {
+		log.Info().Msg("Upstream is shutting down")
+		return
+	}
+
+	deliver(ctx, s.msgs, event.Msg)
+}
+
+// startSnapshot starts collecting the builders of a snapshot, as upstream
+// could not resume the stream and the events that follow replace the state
+// relayed before. Upstream announces it with both the SnapshotComment and a
+// SnapshotMessage, id is the event ID of the latter if known.
+func (s *relayStream) startSnapshot(id *uint64) {
+	s.snapshot = map[string]bool{}
+	s.snapshotID = id
+}
+
+// endSnapshot removes the builders relayed before that are missing from the
+// snapshot just received, as upstream removed them in the meantime.
+func (s *relayStream) endSnapshot(ctx context.Context) {
+	if s.snapshot == nil {
+		return
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(s.mirrored)) {
+		if s.snapshot[name] {
+			continue
+		}
+		log.Info().Msgf("Builder %s was removed upstream", name)
+		delete(s.mirrored, name)
+		deliver(ctx, s.msgs, RemovedMessage{
+			GenericMessage: GenericMessage{
+				MsgType:  "removed",
+				Builder:  name,
+				Received: s.relay.now(),
+				Origin:   OriginSystem,
+			},
+		})
+	}
+	s.snapshot = nil
+}
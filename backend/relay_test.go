@@ -0,0 +1,267 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRelay(url string, opts ...FollowerOption) *RelaySource {
+	return NewRelaySource(url,
+		WithRelayClock(func() time.Time { return testNow }),
+		WithRelayFollowerOptions(append([]FollowerOption{WithFollowerBackoff(time.Millisecond, 10*time.Millisecond)}, opts...)...),
+	)
+}
+
+func TestRelayMirrorsUpstreamBuildStatus(t *testing.T) {
+	upstreamMsgs := make(chan Message)
+	upstream := NewBuildStatusPublisher(upstreamMsgs)
+	server := httptest.NewServer(upstream.handler())
+	defer server.Close()
+
+	// Cancelled before closing the server, which waits for the relay's
+	// connection to end.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go upstream.PublishBuildStatus(ctx)
+
+	for _, m := range [][2]string{
+		{"build/BuilderA/state", "online"},
+		{"build/BuilderA", "pulling git"},
+		{"build/BuilderB", "1/2 3/10 busybox 1.36.1-r0"},
+		{"build/BuilderB/errors", `{"reponame":"main","pkgname":"busybox"}`},
+	} {
+		upstreamMsgs <- MessageFromString(m[0], m[1])
+	}
+
+	localMsgs := make(chan Message)
+	local := NewBuildStatusPublisher(localMsgs)
+	go local.PublishBuildStatus(ctx)
+	go newTestRelay(server.URL+"/events").Run(ctx, localMsgs)
+
+	mirrored := func() bool {
+		want, err := upstream.snapshot(ctx, "")
+		require.NoError(t, err)
+		got, err := local.snapshot(ctx, "")
+		require.NoError(t, err)
+		// Each instance notes when it heard from the builders itself.
+		for i := range want {
+			want[i].LastSeen = time.Time{}
+		}
+		for i := range got {
+			got[i].LastSeen = time.Time{}
+		}
+		return assert.ObjectsAreEqual(want, got)
+	}
+	require.Eventually(t, mirrored, 2*time.Second, 10*time.Millisecond)
+
+	// Live updates, including the removal of a builder.
+	for _, m := range [][2]string{
+		{"build/BuilderB", "idle"},
+		{"build/BuilderA/state", ""},
+		{"build/BuilderA", ""},
+	} {
+		upstreamMsgs <- MessageFromString(m[0], m[1])
+	}
+	require.Eventually(t, mirrored, 2*time.Second, 10*time.Millisecond)
+
+	snapshot, err := local.snapshot(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, "BuilderB", snapshot[0].Builder)
+}
+
+func TestRelayRemovesBuildersMissingFromSnapshot(t *testing.T) {
+	upstreamMsgs := make(chan Message)
+	// Events missed while the relay is away fall out of the history, so it
+	// receives a snapshot when it comes back.
+	upstream := NewBuildStatusPublisher(upstreamMsgs, WithHistorySize(1), WithPingInterval(10*time.Millisecond))
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		upstream.handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go upstream.PublishBuildStatus(ctx)
+	upstreamMsgs <- MessageFromString("build/BuilderA", "pulling git")
+
+	localMsgs := make(chan Message)
+	local := NewBuildStatusPublisher(localMsgs)
+	go local.PublishBuildStatus(ctx)
+	relay := newTestRelay(server.URL + "/events")
+	go relay.Run(ctx, localMsgs)
+
+	localBuilders := func() []string {
+		snapshot, err := local.snapshot(ctx, "")
+		require.NoError(t, err)
+		var names []string
+		for _, b := range snapshot {
+			names = append(names, b.Builder)
+		}
+		return names
+	}
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"BuilderA"}, localBuilders())
+	}, 2*time.Second, 10*time.Millisecond)
+
+	down.Store(true)
+	server.CloseClientConnections()
+	require.Eventually(t, func() bool {
+		return !relay.Status().Report().Ready
+	}, 2*time.Second, time.Millisecond)
+
+	for _, m := range [][2]string{
+		{"build/BuilderA", ""},
+		{"build/BuilderB", "pulling git"},
+		{"build/BuilderB", "upgrading system"},
+	} {
+		upstreamMsgs <- MessageFromString(m[0], m[1])
+	}
+	down.Store(false)
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"BuilderB"}, localBuilders())
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRelayResumesAfterReconnect(t *testing.T) {
+	var requests atomic.Int32
+	lastEventIDs := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		switch requests.Add(1) {
+		case 1:
+			fmt.Fprint(w, ": ping\n\nid: 5\ndata: {\"MsgType\":\"msg\",\"Msg\":\"first\",\"Builder\":\"BuilderA\"}\n\n")
+			fmt.Fprint(w, "retry: 1\nid: 6\ndata: {\"MsgType\":\"system\",\"Status\":\"server-shutting-down\"}\n\n")
+		default:
+			fmt.Fprint(w, "id: 7\ndata: {\"MsgType\":\"msg\",\n")
+			fmt.Fprint(w, "data: \"Msg\":\"second\",\"Builder\":\"BuilderA\"}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	msgs := make(chan Message, 10)
+	relay := newTestRelay(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Run(ctx, msgs)
+
+	var got []string
+	for range 5 {
+		select {
+		case msg := <-msgs:
+			if m, ok := msg.(SystemMessage); ok {
+				assert.Equal(t, testNow, m.Received)
+				got = append(got, m.Status)
+				continue
+			}
+			got = append(got, msg.Get())
+		case <-time.After(2 * time.Second):
+			t.Fatalf("missing messages, got %v", got)
+		}
+	}
+
+	assert.Equal(t, []string{
+		"relay-connected",
+		"BuilderA: first",
+		"relay-disconnected",
+		"relay-connected",
+		"BuilderA: second",
+	}, got)
+	assert.Equal(t, "", <-lastEventIDs)
+	assert.Equal(t, "6", <-lastEventIDs)
+	assert.True(t, relay.Status().Report().Ready)
+}
+
+func TestRelayRetriesFailedConnections(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	msgs := make(chan Message, 10)
+	relay := newTestRelay(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- relay.Run(ctx, msgs)
+	}()
+
+	require.Eventually(t, func() bool {
+		return requests.Load() >= 3
+	}, 2*time.Second, time.Millisecond)
+	cancel()
+
+	assert.NoError(t, <-errCh)
+	assert.Empty(t, msgs, "no connection state changes without a connection")
+	assert.False(t, relay.Status().Report().Ready)
+}
+
+func TestRelayDropsIdleConnections(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	relay := newTestRelay(server.URL,
+		WithFollowerIdleTimeout(20*time.Millisecond),
+		// Keep the relay disconnected after the first connection times out.
+		WithFollowerBackoff(time.Hour, time.Hour),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Run(ctx, make(chan Message, 10))
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(relay.Status().Report().Error, "no data received")
+	}, 2*time.Second, time.Millisecond)
+	assert.False(t, relay.Status().Report().Ready)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRelayStartsSnapshotWithSnapshotEvent(t *testing.T) {
+	msgs := make(chan Message, 8)
+	relay := NewRelaySource("http://upstream/events", WithRelayClock(func() time.Time { return testNow }))
+	stream := &relayStream{relay: relay, msgs: msgs, mirrored: map[string]bool{}}
+	ctx := context.Background()
+
+	stream.Event(ctx, Event{ID: 1, Msg: MessageFromString("build/BuilderA", "pulling git")})
+	stream.Event(ctx, Event{ID: 1, Msg: MessageFromString("build/BuilderB", "pulling git")})
+	// Without the comment, as a browser would see it.
+	stream.Event(ctx, Event{ID: 4, Msg: SnapshotMessage{GenericMessage: GenericMessage{MsgType: "snapshot"}}})
+	stream.Event(ctx, Event{ID: 4, Msg: MessageFromString("build/BuilderB", "upgrading system")})
+	stream.Event(ctx, Event{ID: 5, Msg: MessageFromString("build/BuilderB", "uploading packages to main")})
+
+	var got []string
+	for _, msg := range drainMessages(msgs) {
+		got = append(got, fmt.Sprintf("%s %s", msg.Type(), msg.BuilderName()))
+		if m, ok := msg.(RemovedMessage); ok {
+			assert.Equal(t, testNow, m.Received)
+		}
+	}
+	assert.Equal(t, []string{"msg BuilderA", "msg BuilderB", "msg BuilderB", "removed BuilderA", "msg BuilderB"}, got)
+}
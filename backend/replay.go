@@ -0,0 +1,232 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReplaySource feeds capture files into the publisher, keeping the recorded
+// gaps between messages scaled by the replay speed.
+type ReplaySource struct {
+	paths []string
+	speed float64
+	loop  bool
+	now   func() time.Time
+
+	mu sync.Mutex
+	// resumed is non-nil while paused and closed when the replay resumes.
+	resumed chan struct{}
+}
+
+type ReplayOption func(*ReplaySource)
+
+// WithReplaySpeed sets the replay speed as a multiple of the recorded pace.
+// A speed of 0 replays as fast as the publisher accepts messages.
+func WithReplaySpeed(speed float64) ReplayOption {
+	return func(r *ReplaySource) {
+		r.speed = speed
+	}
+}
+
+// WithReplayLoop restarts the replay from the first file after the last
+// one is finished.
+func WithReplayLoop(loop bool) ReplayOption {
+	return func(r *ReplaySource) {
+		r.loop = loop
+	}
+}
+
+// WithReplayClock sets the clock used to timestamp the replayed messages
+// and the state of the replay.
+func WithReplayClock(now func() time.Time) ReplayOption {
+	return func(r *ReplaySource) {
+		r.now = now
+	}
+}
+
+// WithReplayPaused starts the replay paused until Resume is called.
+func WithReplayPaused(paused bool) ReplayOption {
+	return func(r *ReplaySource) {
+		if paused {
+			r.resumed = make(chan struct{})
+		}
+	}
+}
+
+// NewReplaySource replays the capture files at paths in the given order.
+func NewReplaySource(paths []string, opts ...ReplayOption) *ReplaySource {
+	r := &ReplaySource{
+		paths: paths,
+		speed: 1,
+		now:   time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Pause holds the replay before the next message.
+func (r *ReplaySource) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.resumed == nil {
+		r.resumed = make(chan struct{})
+	}
+}
+
+// Resume continues a paused replay.
+func (r *ReplaySource) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.resumed != nil {
+		close(r.resumed)
+		r.resumed = nil
+	}
+}
+
+// TogglePause pauses a running replay or resumes a paused one and reports
+// whether the replay is paused now.
+func (r *ReplaySource) TogglePause() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.resumed != nil {
+		close(r.resumed)
+		r.resumed = nil
+		return false
+	}
+
+	r.resumed = make(chan struct{})
+	return true
+}
+
+func (r *ReplaySource) waitResumed(ctx context.Context) error {
+	r.mu.Lock()
+	resumed := r.resumed
+	r.mu.Unlock()
+
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run replays the capture files, reporting the start and end of the replay
+// to the dashboard like the connection to a broker.
+func (r *ReplaySource) Run(ctx context.Context, msgs chan<- Message) error {
+	r.report(ctx, msgs, "replay-started", "Replaying captured traffic")
+	for {
+		var last time.Time
+		for _, path := range r.paths {
+			if err := r.replayFile(ctx, path, msgs, &last); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				r.report(ctx, msgs, "replay-finished", "Replay failed")
+				return err
+			}
+		}
+
+		if !r.loop {
+			log.Info().Msg("Replay finished")
+			r.report(ctx, msgs, "replay-finished", "Replay finished")
+			return nil
+		}
+		log.Debug().Msg("Restarting replay")
+	}
+}
+
+func (r *ReplaySource) report(ctx context.Context, msgs chan<- Message, status, msg string) {
+	deliver(ctx, msgs, Stamp(NewSystemMessage(status, msg), r.now(), OriginSystem))
+}
+
+// replayFile delivers the records of a single file. last is the time of the
+// previous record, which may come from the previous file.
+func (r *ReplaySource) replayFile(ctx context.Context, path string, msgs chan<- Message, last *time.Time) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening capture: %w", err)
+	}
+	defer file.Close()
+
+	reader := NewCaptureReader(file)
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if r.speed > 0 && !last.IsZero() {
+			if err := sleep(ctx, time.Duration(float64(record.Time.Sub(*last))/r.speed)); err != nil {
+				return err
+			}
+		}
+		*last = record.Time
+
+		if err := r.waitResumed(ctx); err != nil {
+			return err
+		}
+
+		msg := MessageFromString(record.Topic, record.Payload)
+		if msg == nil {
+			continue
+		}
+		if !deliver(ctx, msgs, Stamp(msg, r.now(), OriginReplay)) {
+			return ctx.Err()
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ParseReplaySpeed parses speeds like 1, 2.5x or max, which replays as fast
+// as possible and is returned as 0.
+func ParseReplaySpeed(s string) (float64, error) {
+	if s == "max" {
+		return 0, nil
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q, expected a positive number or max", s)
+	}
+
+	return speed, nil
+}
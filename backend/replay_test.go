@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaySourceDeliversRecordedMessages(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := writeCapture(t,
+		CaptureRecord{Time: start, Topic: "build/BuilderA", Payload: "pulling git"},
+		CaptureRecord{Time: start.Add(time.Hour), Topic: "build/BuilderA/unknown", Payload: "ignored"},
+	)
+	second := writeCapture(t,
+		CaptureRecord{Time: start.Add(2 * time.Hour), Topic: "build/BuilderA", Payload: "idle"},
+	)
+
+	msgs := make(chan Message, 4)
+	now := start.Add(24 * time.Hour)
+	source := NewReplaySource([]string{first, second}, WithReplaySpeed(0), WithReplayClock(func() time.Time { return now }))
+
+	require.NoError(t, source.Run(t.Context(), msgs))
+
+	assert.Equal(t, Stamp(NewSystemMessage("replay-started", "Replaying captured traffic"), now, OriginSystem), <-msgs)
+	msg := (<-msgs).(GenericMessage)
+	assert.Equal(t, "BuilderA: pulling git", msg.Get())
+	assert.Equal(t, OriginReplay, msg.Origin)
+	assert.Equal(t, now, msg.Received)
+	assert.IsType(t, IdleMessage{}, <-msgs)
+	assert.Equal(t, Stamp(NewSystemMessage("replay-finished", "Replay finished"), now, OriginSystem), <-msgs)
+}
+
+func TestReplaySourceKeepsScaledGaps(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := writeCapture(t,
+		CaptureRecord{Time: start, Topic: "build/BuilderA", Payload: "first"},
+		CaptureRecord{Time: start.Add(2 * time.Second), Topic: "build/BuilderA", Payload: "second"},
+	)
+
+	msgs := make(chan Message, 4)
+	source := NewReplaySource([]string{path}, WithReplaySpeed(20))
+
+	began := time.Now()
+	require.NoError(t, source.Run(t.Context(), msgs))
+
+	assert.GreaterOrEqual(t, time.Since(began), 100*time.Millisecond)
+	assert.Len(t, msgs, 4)
+}
+
+func TestReplaySourceStopsOnCancel(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := writeCapture(t,
+		CaptureRecord{Time: start, Topic: "build/BuilderA", Payload: "first"},
+		CaptureRecord{Time: start.Add(time.Hour), Topic: "build/BuilderA", Payload: "second"},
+	)
+
+	msgs := make(chan Message, 2)
+	source := NewReplaySource([]string{path})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- source.Run(ctx, msgs)
+	}()
+
+	assert.Equal(t, "replay-started", (<-msgs).(SystemMessage).Status)
+	assert.Equal(t, "BuilderA: first", (<-msgs).Get())
+	cancel()
+
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("replay did not stop after cancellation")
+	}
+}
+
+func TestReplaySourcePauses(t *testing.T) {
+	path := writeCapture(t,
+		CaptureRecord{Topic: "build/BuilderA", Payload: "first"},
+	)
+
+	msgs := make(chan Message, 1)
+	source := NewReplaySource([]string{path}, WithReplaySpeed(0), WithReplayPaused(true))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- source.Run(t.Context(), msgs)
+	}()
+
+	assert.Equal(t, "replay-started", (<-msgs).(SystemMessage).Status)
+	select {
+	case <-msgs:
+		t.Fatal("paused replay delivered a message")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.False(t, source.TogglePause())
+	assert.Equal(t, "BuilderA: first", (<-msgs).Get())
+	assert.Equal(t, "replay-finished", (<-msgs).(SystemMessage).Status)
+	assert.NoError(t, <-errCh)
+}
+
+func TestReplaySourceLoops(t *testing.T) {
+	path := writeCapture(t,
+		CaptureRecord{Topic: "build/BuilderA", Payload: "first"},
+		CaptureRecord{Topic: "build/BuilderA", Payload: "second"},
+	)
+
+	msgs := make(chan Message)
+	source := NewReplaySource([]string{path}, WithReplaySpeed(0), WithReplayLoop(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go source.Run(ctx, msgs)
+
+	assert.Equal(t, "replay-started", (<-msgs).(SystemMessage).Status)
+	var payloads []string
+	for range 5 {
+		payloads = append(payloads, (<-msgs).(GenericMessage).Msg)
+	}
+
+	assert.Equal(t, []string{"first", "second", "first", "second", "first"}, payloads)
+}
+
+func TestReplaySourceReportsBrokenCapture(t *testing.T) {
+	path := writeFile(t, "broken.jsonl", "{\"topic\":\"build/BuilderA\",\"payload\":\"ok\"}\nnot json\n")
+
+	source := NewReplaySource([]string{path}, WithReplaySpeed(0))
+	msgs := make(chan Message, 3)
+	err := source.Run(t.Context(), msgs)
+
+	assert.ErrorContains(t, err, "broken.jsonl: line 2:")
+	require.Len(t, msgs, 3)
+	<-msgs
+	<-msgs
+	assert.Equal(t, Stamp(NewSystemMessage("replay-finished", "Replay failed"), time.Time{}, ""), Stamp(<-msgs, time.Time{}, ""))
+}
+
+func TestParseReplaySpeed(t *testing.T) {
+	tests := []struct {
+		value string
+		speed float64
+	}{
+		{value: "1", speed: 1},
+		{value: "2.5x", speed: 2.5},
+		{value: "max", speed: 0},
+	}
+
+	for _, tt := range tests {
+		speed, err := ParseReplaySpeed(tt.value)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.speed, speed, tt.value)
+	}
+
+	for _, invalid := range []string{"", "0", "-1", "fast"} {
+		_, err := ParseReplaySpeed(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func writeCapture(t *testing.T, records ...CaptureRecord) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	recorder, err := NewRecorder(path, 1<<20, 0)
+	require.NoError(t, err)
+	for _, record := range records {
+		require.NoError(t, recorder.Record(record))
+	}
+	require.NoError(t, recorder.Close())
+
+	return path
+}
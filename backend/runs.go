@@ -0,0 +1,260 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultMaxRuns = 10
+
+// RunEnd tells how a build run ended.
+type RunEnd string
+
+const (
+	// RunEndIdle is a run after which the builder reported idle.
+	RunEndIdle RunEnd = "idle"
+	// RunEndInterrupted is a run whose builder went offline or was removed.
+	RunEndInterrupted RunEnd = "interrupted"
+	// RunEndNewRun is a run replaced by a new one before the builder went
+	// idle, as the total progress started over.
+	RunEndNewRun RunEnd = "new-run"
+)
+
+// BuildRun is a single build run of a builder, derived from its progress
+// messages: the total progress counting up from 1/N to N/N, usually followed
+// by idle.
+type BuildRun struct {
+	Builder string
+	Started time.Time
+	Ended   time.Time `json:",omitzero"`
+	// Total is the number of packages the run set out to build.
+	Total    int
+	Packages []RunPackage
+	Errors   []BuildErrorMessage
+	// End is empty while the run is in progress.
+	End RunEnd `json:",omitempty"`
+
+	// progress is the last total progress, which starts over on a new run.
+	progress int
+}
+
+// RunPackage is a package attempted in a run.
+type RunPackage struct {
+	Name    string
+	Version string
+	Started time.Time
+}
+
+func (r BuildRun) clone() BuildRun {
+	r.Packages = slices.Clone(r.Packages)
+	r.Errors = slices.Clone(r.Errors)
+
+	return r
+}
+
+// RunStartedMessage is sent by the publisher when a builder starts a run,
+// and to new subscribers with the run a builder is in.
+type RunStartedMessage struct {
+	GenericMessage
+	Run BuildRun
+}
+
+// RunEndedMessage is sent by the publisher when a run ends.
+type RunEndedMessage struct {
+	GenericMessage
+	Run BuildRun
+}
+
+// BuilderRuns are the current and recent runs of a builder, as returned by
+// the REST API.
+type BuilderRuns struct {
+	Builder string
+	Current *BuildRun
+	// Recent are the runs that ended, oldest first.
+	Recent []BuildRun
+}
+
+// WithMaxRuns sets the number of ended runs kept per builder.
+func WithMaxRuns(n int) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.maxRuns = n
+	}
+}
+
+// trackRun updates the run of the builder with msg, announcing runs that
+// start or end before the message itself. Runs are timed by when their
+// messages were received.
+func (b *BuildStatusPublisher) trackRun(msg Message, bs *BuildStatus) {
+	received := b.receivedAt(msg)
+	switch m := msg.(type) {
+	case BuildStatusMessage:
+		if bs.run != nil && (m.TotalProgress.Total != bs.run.Total || m.TotalProgress.Current < bs.run.progress) {
+			b.endRun(bs, RunEndNewRun, received)
+		}
+		if bs.run == nil {
+			bs.run = &BuildRun{
+				Builder: m.Builder,
+				Started: received,
+				Total:   m.TotalProgress.Total,
+			}
+			log.Debug().Msgf("Builder %s started a run of %d packages", m.Builder, bs.run.Total)
+			b.broadcast(b.runStartedMessage(*bs.run))
+		}
+
+		bs.run.progress = m.TotalProgress.Current
+		packages := bs.run.Packages
+		if n := len(packages); n == 0 || packages[n-1].Name != m.PackageName || packages[n-1].Version != m.PackageVersion {
+			bs.run.Packages = append(bs.run.Packages, RunPackage{
+				Name:    m.PackageName,
+				Version: m.PackageVersion,
+				Started: received,
+			})
+		}
+	case BuildErrorMessage:
+		if bs.run != nil && m.Msg != "" {
+			bs.run.Errors = append(bs.run.Errors, m)
+		}
+	case IdleMessage:
+		b.endRun(bs, RunEndIdle, received)
+	case BuildStateMessage:
+		// Other states, like the empty payload clearing the retained topic,
+		// say nothing about the run.
+		switch m.State {
+		case "offline", "lost":
+			b.endRun(bs, RunEndInterrupted, received)
+		}
+	case RemovedMessage:
+		b.endRun(bs, RunEndInterrupted, received)
+	}
+}
+
+// endRun ends the current run of the builder at the given time, if any.
+func (b *BuildStatusPublisher) endRun(bs *BuildStatus, end RunEnd, ended time.Time) {
+	if bs.run == nil {
+		return
+	}
+
+	run := *bs.run
+	bs.run = nil
+	run.Ended = ended
+	run.End = end
+	bs.runs = append(bs.runs, run)
+	if len(bs.runs) > bs.maxRuns {
+		bs.runs = bs.runs[len(bs.runs)-bs.maxRuns:]
+	}
+
+	log.Debug().Msgf("Run of builder %s ended: %s", run.Builder, end)
+	b.broadcast(b.runEndedMessage(run))
+}
+
+func (b *BuildStatusPublisher) runStartedMessage(run BuildRun) RunStartedMessage {
+	return RunStartedMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "run-started",
+			Msg:      fmt.Sprintf("Started a run of %d packages", run.Total),
+			Builder:  run.Builder,
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+		Run: run.clone(),
+	}
+}
+
+func (b *BuildStatusPublisher) runEndedMessage(run BuildRun) RunEndedMessage {
+	return RunEndedMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "run-ended",
+			Msg:      fmt.Sprintf("Run ended: %s", run.End),
+			Builder:  run.Builder,
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+		Run: run.clone(),
+	}
+}
+
+type runsRequest struct {
+	builder string
+	reply   chan []BuilderRuns
+}
+
+// runs asks the publisher loop for the runs of all builders, or only the
+// named one when builder is not empty.
+func (b *BuildStatusPublisher) runs(ctx context.Context, builder string) ([]BuilderRuns, error) {
+	req := runsRequest{
+		builder: builder,
+		reply:   make(chan []BuilderRuns, 1),
+	}
+
+	select {
+	case b.runsCh <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case runs := <-req.reply:
+		return runs, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// buildRuns must only be called from the publisher loop.
+func (b *BuildStatusPublisher) buildRuns(builder string) []BuilderRuns {
+	runs := []BuilderRuns{}
+	for _, name := range b.builderNames() {
+		if builder != "" && name != builder {
+			continue
+		}
+
+		bs := b.buildStatus[name]
+		r := BuilderRuns{
+			Builder: name,
+			Recent:  []BuildRun{},
+		}
+		if bs.run != nil {
+			current := bs.run.clone()
+			r.Current = &current
+		}
+		for _, run := range bs.runs {
+			r.Recent = append(r.Recent, run.clone())
+		}
+		runs = append(runs, r)
+	}
+
+	return runs
+}
+
+func (b *BuildStatusPublisher) runsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runs, err := b.runs(r.Context(), "")
+		if err != nil {
+			http.Error(w, "publisher unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, runs)
+	}
+}
+
+func (b *BuildStatusPublisher) builderRunsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runs, err := b.runs(r.Context(), r.PathValue("name"))
+		if err != nil {
+			http.Error(w, "publisher unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if len(runs) == 0 {
+			http.Error(w, "unknown builder", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, runs[0])
+	}
+}
@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendAll(publisher *BuildStatusPublisher, channels *publisherChannels, msgs ...Message) {
+	for _, msg := range msgs {
+		channels.msg <- msg
+		publisher.makeStep()
+	}
+}
+
+func TestPublisherTracksRunUntilIdle(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	sendAll(publisher, channels,
+		MessageFromString("build/BuilderA", "pulling git"),
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA", "1/1 2/3 main/musl 1.2.5-r0"),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"musl"}`),
+		MessageFromString("build/BuilderA", "1/1 3/3 main/openssl 3.3.0-r0"),
+	)
+
+	runs := publisher.buildRuns("BuilderA")
+	require.Len(runs, 1)
+	require.NotNil(runs[0].Current)
+	assert.Equal(testNow, runs[0].Current.Started)
+	assert.Equal(3, runs[0].Current.Total)
+	assert.Empty(runs[0].Recent)
+
+	sendAll(publisher, channels, MessageFromString("build/BuilderA", "idle"))
+
+	var types []string
+	for _, msg := range drainMessages(channels.sent) {
+		types = append(types, msg.Type())
+	}
+	assert.Equal([]string{"msg", "run-started", "progress", "progress", "error", "progress", "run-ended", "idle"}, types)
+
+	runs = publisher.buildRuns("BuilderA")
+	assert.Nil(runs[0].Current)
+	require.Len(runs[0].Recent, 1)
+	run := runs[0].Recent[0]
+	assert.Equal("BuilderA", run.Builder)
+	assert.Equal(testNow, run.Ended)
+	assert.Equal(RunEndIdle, run.End)
+	assert.Equal([]RunPackage{
+		{Name: "main/busybox", Version: "1.36.1-r0", Started: testNow},
+		{Name: "main/musl", Version: "1.2.5-r0", Started: testNow},
+		{Name: "main/openssl", Version: "3.3.0-r0", Started: testNow},
+	}, run.Packages)
+	require.Len(run.Errors, 1)
+	assert.Equal("musl", run.Errors[0].Pkgname)
+}
+
+func TestPublisherTimesRunByReceiveTime(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	// Replayed traffic, received long before the publisher sees it.
+	started := testNow.Add(-time.Hour)
+	sendAll(publisher, channels,
+		Stamp(MessageFromString("build/BuilderA", "1/1 1/2 main/busybox 1.36.1-r0"), started, OriginReplay),
+		Stamp(MessageFromString("build/BuilderA", "1/1 2/2 main/musl 1.2.5-r0"), started.Add(time.Minute), OriginReplay),
+		Stamp(MessageFromString("build/BuilderA", "idle"), started.Add(3*time.Minute), OriginReplay),
+	)
+
+	runs := publisher.buildRuns("BuilderA")[0]
+	require.Len(runs.Recent, 1)
+	run := runs.Recent[0]
+	assert.Equal(started, run.Started)
+	assert.Equal(started.Add(3*time.Minute), run.Ended)
+	assert.Equal(started.Add(time.Minute), run.Packages[1].Started)
+}
+
+func TestPublisherStartsNewRunWhenProgressStartsOver(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t, WithMaxRuns(2))
+	defer cancel()
+
+	sendAll(publisher, channels,
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA", "1/1 2/3 main/musl 1.2.5-r0"),
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r1"),
+		MessageFromString("build/BuilderA", "1/1 1/2 community/packageA 1.0.0-r0"),
+		MessageFromString("build/BuilderA", "1/1 1/1 community/packageB 1.0.0-r0"),
+	)
+
+	runs := publisher.buildRuns("BuilderA")[0]
+	require.NotNil(runs.Current)
+	assert.Equal("community/packageB", runs.Current.Packages[0].Name)
+	require.Len(runs.Recent, 2, "only the configured number of runs is kept")
+	assert.Equal(RunEndNewRun, runs.Recent[0].End)
+	assert.Equal("main/busybox", runs.Recent[0].Packages[0].Name)
+	assert.Equal(3, runs.Recent[0].Total)
+	assert.Equal(RunEndNewRun, runs.Recent[1].End)
+	assert.Equal(2, runs.Recent[1].Total)
+}
+
+func TestPublisherInterruptsRunWhenBuilderGoesOffline(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	sendAll(publisher, channels,
+		MessageFromString("build/BuilderA/state", "online"),
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA/state", "offline"),
+	)
+
+	runs := publisher.buildRuns("BuilderA")[0]
+	require.Nil(runs.Current)
+	require.Len(runs.Recent, 1)
+	require.Equal(RunEndInterrupted, runs.Recent[0].End)
+}
+
+func TestPublisherKeepsRunWhenStateIsCleared(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	sendAll(publisher, channels,
+		MessageFromString("build/BuilderA/state", "online"),
+		MessageFromString("build/BuilderA", "1/2 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA/state", ""),
+		MessageFromString("build/BuilderA", "2/2 2/3 main/musl 1.2.5-r0"),
+	)
+
+	runs := publisher.buildRuns("BuilderA")[0]
+	require.NotNil(runs.Current)
+	require.Len(runs.Current.Packages, 2)
+	require.Empty(runs.Recent, "clearing the state does not interrupt the run")
+}
+
+func TestPublisherSendsCurrentRunToNewSubscribers(t *testing.T) {
+	require := require.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	sendAll(publisher, channels,
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA", "idle"),
+		MessageFromString("build/BuilderB", "1/1 1/3 main/busybox 1.36.1-r0"),
+	)
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	var started []RunStartedMessage
+	for _, msg := range drainMessages(channels.sent) {
+		require.NotEqual("run-ended", msg.Type(), "ended runs are only available from the API")
+		if m, ok := msg.(RunStartedMessage); ok {
+			started = append(started, m)
+		}
+	}
+	require.Len(started, 1)
+	require.Equal("BuilderB", started[0].Run.Builder)
+	require.Len(started[0].Run.Packages, 1)
+}
+
+func TestPublisherIgnoresRelayedRuns(t *testing.T) {
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	sendAll(publisher, channels,
+		RunStartedMessage{GenericMessage: GenericMessage{MsgType: "run-started", Msg: "Started", Builder: "BuilderA"}, Run: BuildRun{Builder: "BuilderA", Total: 3}},
+		RunEndedMessage{GenericMessage: GenericMessage{MsgType: "run-ended", Msg: "Ended", Builder: "BuilderA"}, Run: BuildRun{Builder: "BuilderA", End: RunEndIdle}},
+	)
+
+	assert.Empty(t, drainMessages(channels.sent))
+	assert.Empty(t, publisher.buildRuns(""))
+}
+
+func TestAPIListsRuns(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, cancel := createPublisher(t)
+	defer cancel()
+
+	sendAll(publisher, channels,
+		MessageFromString("build/BuilderB", "pulling git"),
+		MessageFromString("build/BuilderA", "1/1 1/3 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA", "idle"),
+		MessageFromString("build/BuilderA", "1/1 1/1 main/musl 1.2.5-r0"),
+	)
+
+	recorder := requestAPI(t, publisher, "/api/runs")
+	require.Equal(http.StatusOK, recorder.Code)
+
+	var runs []BuilderRuns
+	require.NoError(json.Unmarshal(recorder.Body.Bytes(), &runs))
+	require.Len(runs, 2)
+	assert.Equal("BuilderA", runs[0].Builder)
+	require.NotNil(runs[0].Current)
+	assert.Equal("main/musl", runs[0].Current.Packages[0].Name)
+	require.Len(runs[0].Recent, 1)
+	assert.Equal(RunEndIdle, runs[0].Recent[0].End)
+	assert.Equal("BuilderB", runs[1].Builder)
+	assert.Nil(runs[1].Current)
+	assert.Empty(runs[1].Recent)
+
+	recorder = requestAPI(t, publisher, "/api/builders/BuilderA/runs")
+	require.Equal(http.StatusOK, recorder.Code)
+	var builderRuns BuilderRuns
+	require.NoError(json.Unmarshal(recorder.Body.Bytes(), &builderRuns))
+	assert.Equal(runs[0], builderRuns)
+
+	recorder = requestAPI(t, publisher, "/api/builders/unknown/runs")
+	assert.Equal(http.StatusNotFound, recorder.Code)
+}
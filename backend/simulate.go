@@ -0,0 +1,258 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const defaultSimulatedBuilders = 6
+
+var (
+	simulatedReleases = []string{"edge", "3-21", "3-20"}
+	simulatedArches   = []string{"x86_64", "aarch64", "armv7", "riscv64", "x86", "armhf", "ppc64le", "s390x", "loongarch64"}
+	simulatedPackages = []string{
+		"abuild", "busybox", "curl", "gcc", "git", "go", "linux-lts", "llvm19",
+		"musl", "nodejs", "openssl", "perl", "python3", "rust", "zlib",
+	}
+)
+
+// Simulator generates the MQTT traffic of fake builders walking through
+// build cycles: pulling git, upgrading the system, building packages with
+// progress lines, occasional errors and state changes, and idling. With the
+// same seed it always generates the same traffic.
+type Simulator struct {
+	rand     *rand.Rand
+	speed    float64
+	now      func() time.Time
+	builders []*simulatedBuilder
+}
+
+type SimulatorOption func(*Simulator)
+
+// WithSimulatorSeed makes the generated traffic reproducible.
+func WithSimulatorSeed(seed uint64) SimulatorOption {
+	return func(s *Simulator) {
+		s.rand = rand.New(rand.NewPCG(seed, seed))
+	}
+}
+
+// WithSimulatorBuilders sets the number of simulated builders.
+func WithSimulatorBuilders(n int) SimulatorOption {
+	return func(s *Simulator) {
+		s.builders = nil
+		for _, name := range SimulatedBuilderNames(n) {
+			s.builders = append(s.builders, &simulatedBuilder{name: name})
+		}
+	}
+}
+
+// WithSimulatorSpeed shortens the delays between messages by a factor. A
+// speed of 0 generates messages without any delay.
+func WithSimulatorSpeed(speed float64) SimulatorOption {
+	return func(s *Simulator) {
+		s.speed = speed
+	}
+}
+
+// WithSimulatorClock sets the clock used to timestamp the messages fed to
+// the publisher and the state of the simulation.
+func WithSimulatorClock(now func() time.Time) SimulatorOption {
+	return func(s *Simulator) {
+		s.now = now
+	}
+}
+
+func NewSimulator(opts ...SimulatorOption) *Simulator {
+	s := &Simulator{
+		rand:  rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		speed: 1,
+		now:   time.Now,
+	}
+	WithSimulatorBuilders(defaultSimulatedBuilders)(s)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SimulatedBuilderNames returns n builder names like build-edge-x86_64,
+// covering all architectures of a release before the next release.
+func SimulatedBuilderNames(n int) []string {
+	var names []string
+	for _, release := range simulatedReleases {
+		for _, arch := range simulatedArches {
+			if len(names) == n {
+				return names
+			}
+			names = append(names, fmt.Sprintf("build-%s-%s", release, arch))
+		}
+	}
+
+	return names
+}
+
+type simulatedBuilder struct {
+	name  string
+	steps []simulatedStep
+	// next is the simulated time at which the first step is due.
+	next time.Duration
+}
+
+type simulatedStep struct {
+	topic   string
+	payload string
+	// delay is the time until the step after this one.
+	delay time.Duration
+}
+
+// Generate calls emit with the topic and payload of each simulated message
+// until ctx is cancelled or emit fails.
+func (s *Simulator) Generate(ctx context.Context, emit func(topic, payload string) error) error {
+	if len(s.builders) == 0 {
+		return errors.New("no builders to simulate")
+	}
+
+	for _, builder := range s.builders {
+		builder.steps = []simulatedStep{{
+			topic:   "build/" + builder.name + "/state",
+			payload: "online",
+			delay:   s.between(0, 3*time.Second),
+		}}
+	}
+
+	var now time.Duration
+	for {
+		builder := s.builders[0]
+		for _, b := range s.builders[1:] {
+			if b.next < builder.next {
+				builder = b
+			}
+		}
+
+		if s.speed > 0 {
+			if err := sleep(ctx, time.Duration(float64(builder.next-now)/s.speed)); err != nil {
+				return nil
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+		now = builder.next
+
+		step := builder.steps[0]
+		builder.steps = builder.steps[1:]
+		if len(builder.steps) == 0 {
+			builder.steps = s.cycle(builder.name)
+		}
+		builder.next += step.delay
+
+		if err := emit(step.topic, step.payload); err != nil {
+			return err
+		}
+	}
+}
+
+// cycle returns the steps of a builder's next build, ending in idle.
+func (s *Simulator) cycle(name string) []simulatedStep {
+	topic := "build/" + name
+	var steps []simulatedStep
+
+	if s.rand.IntN(10) == 0 {
+		state := "offline"
+		if s.rand.IntN(3) == 0 {
+			state = "lost"
+		}
+		steps = append(steps,
+			simulatedStep{topic: topic + "/state", payload: state, delay: s.between(30*time.Second, 2*time.Minute)},
+			simulatedStep{topic: topic + "/state", payload: "online", delay: s.between(time.Second, 5*time.Second)},
+		)
+	}
+
+	steps = append(steps,
+		simulatedStep{topic: topic, payload: "pulling git", delay: s.between(time.Second, 4*time.Second)},
+		simulatedStep{topic: topic, payload: "upgrading system", delay: s.between(2*time.Second, 8*time.Second)},
+	)
+
+	repos := []string{"main", "community"}
+	if strings.HasPrefix(name, "build-edge-") {
+		repos = append(repos, "testing")
+	}
+
+	total := 0
+	counts := make([]int, len(repos))
+	for i := range repos {
+		counts[i] = 1 + s.rand.IntN(12)
+		total += counts[i]
+	}
+
+	built := 0
+	for i, repo := range repos {
+		for n := 1; n <= counts[i]; n++ {
+			built++
+			pkg := simulatedPackages[s.rand.IntN(len(simulatedPackages))]
+			version := fmt.Sprintf("%d.%d.%d-r%d", s.rand.IntN(4), s.rand.IntN(20), s.rand.IntN(10), s.rand.IntN(3))
+			steps = append(steps, simulatedStep{
+				topic:   topic,
+				payload: fmt.Sprintf("%d/%d %d/%d %s/%s %s", n, counts[i], built, total, repo, pkg, version),
+				delay:   s.between(2*time.Second, 20*time.Second),
+			})
+
+			if s.rand.IntN(20) == 0 {
+				payload, _ := json.Marshal(map[string]string{
+					"reponame": repo,
+					"pkgname":  pkg,
+					"logurl":   fmt.Sprintf("https://build.alpinelinux.org/buildlogs/%s/%s/%s/%s-%s.log", name, repo, pkg, pkg, version),
+					"hostname": name,
+				})
+				steps = append(steps, simulatedStep{
+					topic:   topic + "/errors",
+					payload: string(payload),
+					delay:   s.between(time.Second, 3*time.Second),
+				})
+			}
+		}
+	}
+
+	return append(steps, simulatedStep{topic: topic, payload: "idle", delay: s.between(10*time.Second, time.Minute)})
+}
+
+func (s *Simulator) between(lo, hi time.Duration) time.Duration {
+	return lo + time.Duration(s.rand.Int64N(int64(hi-lo)+1))
+}
+
+// Run feeds the simulated messages directly to the publisher, reporting the
+// start and end of the simulation to the dashboard like the connection to a
+// broker.
+func (s *Simulator) Run(ctx context.Context, msgs chan<- Message) error {
+	deliver(ctx, msgs, Stamp(NewSystemMessage("simulator-started", "Simulating builders"), s.now(), OriginSystem))
+	err := s.Generate(ctx, func(topic, payload string) error {
+		if msg := MessageFromString(topic, payload); msg != nil {
+			deliver(ctx, msgs, Stamp(msg, s.now(), OriginSimulator))
+		}
+		return nil
+	})
+	if ctx.Err() == nil {
+		deliver(ctx, msgs, Stamp(NewSystemMessage("simulator-finished", "Simulation stopped"), s.now(), OriginSystem))
+	}
+
+	return err
+}
+
+// Publish sends the simulated messages to an MQTT broker. They are not
+// retained, so the simulated builders do not outlive the simulation.
+func (s *Simulator) Publish(ctx context.Context, client mqtt.Client, qos byte) error {
+	return s.Generate(ctx, func(topic, payload string) error {
+		if t := client.Publish(topic, qos, false, payload); t.Wait() && t.Error() != nil {
+			return fmt.Errorf("error publishing to %s: %w", topic, t.Error())
+		}
+		return nil
+	})
+}
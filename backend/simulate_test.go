@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errEnoughMessages = errors.New("enough messages")
+
+type simulatedMessage struct {
+	topic   string
+	payload string
+}
+
+// simulate collects the first n messages of a simulator without delays.
+func simulate(t *testing.T, n int, opts ...SimulatorOption) []simulatedMessage {
+	t.Helper()
+
+	var messages []simulatedMessage
+	simulator := NewSimulator(append(opts, WithSimulatorSpeed(0))...)
+	err := simulator.Generate(t.Context(), func(topic, payload string) error {
+		messages = append(messages, simulatedMessage{topic: topic, payload: payload})
+		if len(messages) == n {
+			return errEnoughMessages
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errEnoughMessages)
+
+	return messages
+}
+
+func TestSimulatorIsReproducibleWithSeed(t *testing.T) {
+	first := simulate(t, 500, WithSimulatorSeed(42))
+	second := simulate(t, 500, WithSimulatorSeed(42))
+	other := simulate(t, 500, WithSimulatorSeed(43))
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, other)
+}
+
+func TestSimulatorGeneratesBuildCycles(t *testing.T) {
+	assert := assert.New(t)
+
+	messages := simulate(t, 2000, WithSimulatorSeed(1), WithSimulatorBuilders(2))
+
+	types := map[string]int{}
+	for i, m := range messages {
+		if i < 2 {
+			assert.Equal("online", m.payload, "builders start online")
+		}
+		assert.True(strings.HasPrefix(m.topic, "build/build-edge-x86_64") || strings.HasPrefix(m.topic, "build/build-edge-aarch64"), m.topic)
+
+		msg := MessageFromString(m.topic, m.payload)
+		require.NotNil(t, msg, m.topic)
+		types[msg.Type()]++
+		if progress, ok := msg.(BuildStatusMessage); ok {
+			repo, pkg, found := strings.Cut(progress.PackageName, "/")
+			assert.True(found, "packages are named repo/pkg: %s", progress.PackageName)
+			assert.Contains([]string{"main", "community", "testing"}, repo)
+			assert.NotEmpty(pkg)
+		}
+		if m.payload == "pulling git" || m.payload == "upgrading system" {
+			types[m.payload]++
+		}
+	}
+
+	for _, typ := range []string{"state", "pulling git", "upgrading system", "progress", "error", "idle"} {
+		assert.Positive(types[typ], typ)
+	}
+}
+
+func TestSimulatedBuilderNames(t *testing.T) {
+	names := SimulatedBuilderNames(11)
+
+	assert.Len(t, names, 11)
+	assert.Equal(t, "build-edge-x86_64", names[0])
+	assert.Equal(t, "build-3-21-x86_64", names[9])
+	assert.Equal(t, "build-3-21-aarch64", names[10])
+}
+
+func TestSimulatorFeedsPublisher(t *testing.T) {
+	msgs := make(chan Message)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	simulator := NewSimulator(WithSimulatorSeed(1), WithSimulatorBuilders(1), WithSimulatorSpeed(0), WithSimulatorClock(func() time.Time { return now }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- simulator.Run(ctx, msgs)
+	}()
+
+	started := <-msgs
+	msg := <-msgs
+	cancel()
+
+	assert.Equal(t, Stamp(NewSystemMessage("simulator-started", "Simulating builders"), now, OriginSystem), started)
+	require.IsType(t, BuildStateMessage{}, msg)
+	state := msg.(BuildStateMessage)
+	assert.Equal(t, OriginSimulator, state.Origin)
+	assert.Equal(t, now, state.Received)
+	assert.Equal(t, BuildStateMessage{
+		GenericMessage: GenericMessage{MsgType: "state", Msg: "online", Builder: "build-edge-x86_64"},
+		State:          "online",
+	}, Stamp(msg, time.Time{}, ""))
+	assert.NoError(t, <-errCh)
+}
+
+func TestSimulatorPublishesMessagesWithoutRetaining(t *testing.T) {
+	client := &fakeMQTTClient{publishErr: errors.New("not connected")}
+	simulator := NewSimulator(WithSimulatorSeed(1), WithSimulatorBuilders(1), WithSimulatorSpeed(0))
+
+	err := simulator.Publish(t.Context(), client, 1)
+
+	assert.ErrorContains(t, err, "not connected")
+	assert.Equal(t, []string{"build/build-edge-x86_64/state"}, client.published)
+	assert.Empty(t, client.retained)
+}
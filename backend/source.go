@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Source delivers build messages into the publisher's channel. Run blocks
+// until ctx is cancelled or the source fails. Returning nil before ctx is
+// cancelled means the source is exhausted, the server keeps running.
+type Source interface {
+	Run(ctx context.Context, msgs chan<- Message) error
+}
+
+// SourceFunc adapts a function to the Source interface.
+type SourceFunc func(ctx context.Context, msgs chan<- Message) error
+
+func (f SourceFunc) Run(ctx context.Context, msgs chan<- Message) error {
+	return f(ctx, msgs)
+}
+
+// SourceNames lists the sources that can be enabled in the configuration.
+var SourceNames = []string{"mqtt", "ingest", "relay"}
+
+func validSource(name string) bool {
+	return slices.Contains(SourceNames, name)
+}
+
+// deliver sends msg unless ctx is cancelled first, so sources do not block
+// on a publisher that has stopped.
+func deliver(ctx context.Context, msgs chan<- Message, msg Message) bool {
+	select {
+	case msgs <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// NewSources creates the sources enabled in the configuration and the
+// publisher options they require.
+func NewSources(config Config) ([]Source, []PublisherOption, error) {
+	var (
+		sources []Source
+		opts    []PublisherOption
+	)
+
+	if config.EmbeddedBroker.Listen != "" {
+		broker, err := NewEmbeddedBroker(config.EmbeddedBroker.Listen)
+		if err != nil {
+			return nil, nil, fmt.Errorf("embedded broker: %w", err)
+		}
+		sources = append(sources, broker)
+		config.MQTT.Broker = broker.URL()
+	}
+
+	for i, name := range config.Sources {
+		if slices.Contains(config.Sources[:i], name) {
+			return nil, nil, fmt.Errorf("source %q is listed more than once", name)
+		}
+
+		switch name {
+		case "mqtt":
+			source, err := NewMQTTSource(config.MQTT)
+			if err != nil {
+				return nil, nil, fmt.Errorf("mqtt: %w", err)
+			}
+			sources = append(sources, source)
+			opts = append(opts, WithBrokerStatus(source.Status()))
+		case "ingest":
+			source, err := NewIngestSource(config.Ingest.TokenFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("ingest: %w", err)
+			}
+			sources = append(sources, source)
+			opts = append(opts, source.Options()...)
+		case "relay":
+			source := NewRelaySource(config.Relay.URL, WithRelayFollowerOptions(WithFollowerIdleTimeout(config.Relay.IdleTimeout)))
+			sources = append(sources, source)
+			opts = append(opts, WithBrokerStatus(source.Status()))
+		default:
+			return nil, nil, fmt.Errorf("unknown source %q", name)
+		}
+	}
+
+	return sources, opts, nil
+}
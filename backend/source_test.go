@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSourcesFromConfig(t *testing.T) {
+	sources, opts, err := NewSources(DefaultConfig())
+	require.NoError(t, err)
+
+	require.Len(t, sources, 1)
+	assert.IsType(t, &MQTTSource{}, sources[0])
+	assert.Len(t, opts, 1)
+}
+
+func TestNewSourcesRejectsUnknownSource(t *testing.T) {
+	config := DefaultConfig()
+	config.Sources = []string{"carrier-pigeon"}
+
+	_, _, err := NewSources(config)
+
+	assert.ErrorContains(t, err, `unknown source "carrier-pigeon"`)
+}
+
+func TestNewSourcesRejectsDuplicateSource(t *testing.T) {
+	config := DefaultConfig()
+	config.Sources = []string{"relay", "relay"}
+
+	_, _, err := NewSources(config)
+
+	assert.ErrorContains(t, err, `source "relay" is listed more than once`)
+}
+
+func TestDeliverStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, deliver(ctx, make(chan Message), NewSystemMessage("test", "blocked")))
+}
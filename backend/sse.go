@@ -5,29 +5,89 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
 	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
 type Connection interface {
-	WriteJSON(v any) error
+	WriteEvent(e Event) error
 	WriteComment(text string) error
 	RemoteAddr() net.Addr
 	Close() error
 }
 
+// Event is a message broadcast by the publisher together with its sequence
+// number, which is sent to clients as the SSE event ID. An EventReader
+// returns the events it reads in the same form.
+type Event struct {
+	ID  uint64
+	Msg Message
+	// Retry tells clients how long to wait before reconnecting.
+	Retry time.Duration
+
+	data []byte
+}
+
+// newEvent creates an event with its message already encoded, so the same
+// bytes can be written to every subscriber.
+func newEvent(id uint64, msg Message) Event {
+	e := Event{ID: id, Msg: msg}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to encode %T", msg)
+		return e
+	}
+	e.data = data
+
+	return e
+}
+
+// encode returns the encoded message, encoding it only if the event was not
+// created with newEvent.
+func (e Event) encode() ([]byte, error) {
+	if e.data != nil {
+		return e.data, nil
+	}
+
+	return json.Marshal(e.Msg)
+}
+
+// resumableConnection is implemented by connections that may continue a
+// previous stream, like SSE clients sending a Last-Event-ID header.
+type resumableConnection interface {
+	LastEventID() (uint64, bool)
+}
+
 type BuildStatus struct {
 	maxMsgLen int
+	maxErrors int
+	maxRuns   int
 	msgs      []Message
 	state     *BuildStateMessage
 	error     *Message
+	// errors keeps the recent build errors, which survive the builder
+	// going idle unlike error.
+	errors   []BuildErrorMessage
+	progress *BuildStatusMessage
+	lastSeen time.Time
+	stale    *StaleMessage
+	restored *RestoredMessage
+	// run is the run in progress and runs the ones that ended.
+	run  *BuildRun
+	runs []BuildRun
 }
 
 func (bs *BuildStatus) addMsg(msg Message) bool {
-	if len(bs.msgs) > 0 && bs.msgs[len(bs.msgs)-1] == msg {
+	if len(bs.msgs) > 0 && sameMessage(bs.msgs[len(bs.msgs)-1], msg) {
 		return false
 	}
 	bs.msgs = append(bs.msgs, msg)
@@ -43,6 +103,7 @@ func (bs *BuildStatus) addMsg(msg Message) bool {
 
 func (bs *BuildStatus) clearMsgs() {
 	bs.msgs = nil
+	bs.progress = nil
 }
 
 func (bs *BuildStatus) isEmpty() bool {
@@ -52,37 +113,205 @@ func (bs *BuildStatus) isEmpty() bool {
 type BuildStatusPublisher struct {
 	msgChan     chan Message
 	connChan    chan Connection
-	connCloseCh chan string
+	connCloseCh chan Connection
+	// done is closed once the publisher loop stopped. stopped is then set
+	// under stopMu, so no connection is queued for a loop that is gone.
+	done        chan struct{}
+	stopMu      sync.Mutex
+	stopped     bool
 	buildStatus map[string]*BuildStatus
-	subscribers map[string]Connection
+	subscribers map[Connection]*subscriber
+	snapshotCh  chan snapshotRequest
+	runsCh      chan runsRequest
+	probeCh     chan chan struct{}
+	lastEventID uint64
+	history     *eventRing
 	stepChan    chan struct{}
+
+	maxMsgLen      int
+	maxErrors      int
+	maxRuns        int
+	pingInterval   time.Duration
+	queueSize      int
+	overflowPolicy OverflowPolicy
+
+	droppedSubscribers  atomic.Uint64
+	resyncedSubscribers atomic.Uint64
+	metrics             *publisherMetrics
+	brokers             []*BrokerStatus
+	staticFS            fs.FS
+	extraHandlers       []routedHandler
+	now                 func() time.Time
+
+	staleAfter      time.Duration
+	staleAfterState map[string]time.Duration
+	staleCheck      <-chan time.Time
+
+	stateFile         string
+	stateSaveInterval time.Duration
+}
+
+type routedHandler struct {
+	pattern string
+	handler http.Handler
+}
+
+const (
+	defaultMaxMessages  = 3
+	defaultMaxErrors    = 10
+	defaultPingInterval = 15 * time.Second
+)
+
+// SnapshotComment precedes the events of a snapshot on the event stream.
+const SnapshotComment = "snapshot"
+
+// shutdownRetry is the reconnect delay suggested to clients when the server
+// shuts down, giving a restarted instance time to come up.
+const shutdownRetry = 5 * time.Second
+
+type PublisherOption func(*BuildStatusPublisher)
+
+// WithMaxMessages sets the number of recent messages kept per builder.
+func WithMaxMessages(n int) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.maxMsgLen = n
+	}
+}
+
+// WithMaxErrors sets the number of recent build errors kept per builder.
+func WithMaxErrors(n int) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.maxErrors = n
+	}
+}
+
+// WithPingInterval sets how often subscribers are sent a keepalive comment.
+func WithPingInterval(interval time.Duration) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.pingInterval = interval
+	}
+}
+
+// WithHistorySize sets the number of events kept for resuming clients.
+func WithHistorySize(size int) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.history = newEventRing(size)
+	}
+}
+
+// WithQueueSize sets the number of frames buffered for each subscriber
+// before the overflow policy applies.
+func WithQueueSize(size int) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.queueSize = size
+	}
+}
+
+func WithOverflowPolicy(policy OverflowPolicy) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.overflowPolicy = policy
+	}
+}
+
+// WithClock sets the clock used to timestamp the messages the publisher
+// creates itself.
+func WithClock(now func() time.Time) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.now = now
+	}
+}
+
+// WithHandler serves handler for pattern next to the publisher's own
+// endpoints, for sources that receive messages over HTTP.
+func WithHandler(pattern string, handler http.Handler) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.extraHandlers = append(b.extraHandlers, routedHandler{pattern: pattern, handler: handler})
+	}
 }
 
-func NewBuildStatusPublisher(msgChan chan Message) *BuildStatusPublisher {
+func NewBuildStatusPublisher(msgChan chan Message, opts ...PublisherOption) *BuildStatusPublisher {
 	connChan := make(chan Connection, 16)
-	return &BuildStatusPublisher{
+	b := &BuildStatusPublisher{
 		msgChan:     msgChan,
 		connChan:    connChan,
-		connCloseCh: make(chan string, 16),
+		connCloseCh: make(chan Connection, 16),
+		done:        make(chan struct{}),
 		buildStatus: map[string]*BuildStatus{},
-		subscribers: map[string]Connection{},
+		subscribers: map[Connection]*subscriber{},
+		snapshotCh:  make(chan snapshotRequest),
+		runsCh:      make(chan runsRequest),
+		probeCh:     make(chan chan struct{}),
+		// Seed event IDs with the start time so they keep increasing across
+		// restarts and clients never resume from an unrelated stream.
+		lastEventID:  uint64(time.Now().UnixMicro()),
+		history:      newEventRing(defaultHistorySize),
+		maxMsgLen:    defaultMaxMessages,
+		maxErrors:    defaultMaxErrors,
+		maxRuns:      defaultMaxRuns,
+		pingInterval: defaultPingInterval,
+		queueSize:    defaultQueueSize,
+		metrics:      newPublisherMetrics(),
+		staticFS:     embeddedStaticFS(),
+		now:          time.Now,
+
+		stateSaveInterval: defaultStateSaveInterval,
+	}
+
+	for _, opt := range opts {
+		opt(b)
 	}
+
+	return b
+}
+
+// DroppedSubscribers returns the number of subscribers disconnected because
+// their send queue overflowed.
+func (b *BuildStatusPublisher) DroppedSubscribers() uint64 {
+	return b.droppedSubscribers.Load()
+}
+
+// ResyncedSubscribers returns the number of times a subscriber's overflowing
+// queue was replaced with a snapshot.
+func (b *BuildStatusPublisher) ResyncedSubscribers() uint64 {
+	return b.resyncedSubscribers.Load()
 }
 
 func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
-	pingTicker := time.NewTicker(15 * time.Second)
+	pingTicker := time.NewTicker(b.pingInterval)
 	defer pingTicker.Stop()
 
+	staleCheck := b.staleCheck
+	if staleCheck == nil && b.detectsStale() {
+		staleTicker := time.NewTicker(staleCheckInterval)
+		defer staleTicker.Stop()
+		staleCheck = staleTicker.C
+	}
+
+	var stateSave <-chan time.Time
+	if b.stateFile != "" {
+		if err := b.restoreState(); err != nil {
+			log.Error().Err(err).Msgf("Failed to restore state from %s", b.stateFile)
+		}
+		saveTicker := time.NewTicker(b.stateSaveInterval)
+		defer saveTicker.Stop()
+		stateSave = saveTicker.C
+	}
+
 	for {
 		select {
 		case msg := <-b.msgChan:
+			b.metrics.observe(msg)
 			if _, ok := b.buildStatus[msg.BuilderName()]; !ok {
 				b.buildStatus[msg.BuilderName()] = &BuildStatus{
-					maxMsgLen: 3,
+					maxMsgLen: b.maxMsgLen,
+					maxErrors: b.maxErrors,
+					maxRuns:   b.maxRuns,
 				}
 			}
 			buildStatus := b.buildStatus[msg.BuilderName()]
 			hadState := !buildStatus.isEmpty()
+			b.heardFrom(msg, buildStatus)
+			b.trackRun(msg, buildStatus)
 
 			switch m := msg.(type) {
 			case BuildErrorMessage:
@@ -90,12 +319,13 @@ func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
 					buildStatus.error = nil
 				} else {
 					buildStatus.error = &msg
+					buildStatus.errors = AppendError(buildStatus.errors, m, buildStatus.maxErrors)
 				}
 			case BuildStateMessage:
 				if m.State == "" {
 					buildStatus.state = nil
 				} else {
-					if buildStatus.state != nil && *buildStatus.state == m {
+					if buildStatus.state != nil && sameMessage(*buildStatus.state, m) {
 						b.waitStep()
 						continue
 					}
@@ -106,6 +336,54 @@ func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
 				log.Debug().Msgf("Received idle for %s, resetting state", msg.BuilderName())
 				buildStatus.msgs = []Message{msg}
 				buildStatus.error = nil
+				buildStatus.progress = nil
+			case RemovedMessage:
+				// Relayed from another instance which removed the builder.
+				buildStatus.clearMsgs()
+				buildStatus.state = nil
+				buildStatus.error = nil
+				buildStatus.errors = nil
+			case ErrorHistoryMessage:
+				// Relayed from another instance, replacing what we have.
+				buildStatus.errors = nil
+				for _, err := range m.Errors {
+					buildStatus.errors = AppendError(buildStatus.errors, err, buildStatus.maxErrors)
+				}
+			case SnapshotMessage:
+				// Relayed from another instance, subscribers get their own.
+				if !hadState {
+					delete(b.buildStatus, msg.BuilderName())
+				}
+				b.waitStep()
+				continue
+			case RunStartedMessage, RunEndedMessage:
+				// Relayed from another instance, the runs are derived from
+				// the relayed progress messages instead.
+				if !hadState {
+					delete(b.buildStatus, msg.BuilderName())
+				}
+				b.waitStep()
+				continue
+			case RestoredMessage:
+				// Relayed from another instance which restored the builder.
+				if m.Restored {
+					buildStatus.restored = &m
+				} else if buildStatus.restored == nil {
+					b.waitStep()
+					continue
+				} else {
+					buildStatus.restored = nil
+				}
+			case StaleMessage:
+				// Relayed from another instance which detected it.
+				if m.Stale {
+					buildStatus.stale = &m
+				} else if buildStatus.stale == nil {
+					b.waitStep()
+					continue
+				} else {
+					buildStatus.stale = nil
+				}
 			default:
 				if m, ok := msg.(GenericMessage); ok && m.Msg == "" {
 					buildStatus.clearMsgs()
@@ -115,13 +393,9 @@ func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
 							b.waitStep()
 							continue
 						}
+						b.endRun(buildStatus, RunEndInterrupted, b.receivedAt(msg))
 						delete(b.buildStatus, msg.BuilderName())
-						msg = RemovedMessage{
-							GenericMessage: GenericMessage{
-								MsgType: "removed",
-								Builder: msg.BuilderName(),
-							},
-						}
+						msg = b.removedMessage(msg.BuilderName())
 						break
 					}
 					b.waitStep()
@@ -131,6 +405,9 @@ func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
 					b.waitStep()
 					continue
 				}
+				if m, ok := msg.(BuildStatusMessage); ok {
+					buildStatus.progress = &m
+				}
 				log.Trace().Msgf("builder %s, %d messages", msg.BuilderName(), len(buildStatus.msgs))
 			}
 
@@ -140,61 +417,58 @@ func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
 					b.waitStep()
 					continue
 				}
+				b.endRun(buildStatus, RunEndInterrupted, b.receivedAt(msg))
 				delete(b.buildStatus, msg.BuilderName())
-				msg = RemovedMessage{
-					GenericMessage: GenericMessage{
-						MsgType: "removed",
-						Builder: msg.BuilderName(),
-					},
-				}
+				msg = b.removedMessage(msg.BuilderName())
 			} else if m, ok := msg.(BuildStateMessage); ok && m.State == "" {
 				b.waitStep()
 				continue
 			}
 
-			log.Debug().Msgf("%T{%s}", msg, msg.Get())
-			for _, conn := range b.subscribers {
-				log.Trace().Msgf("Sending message to %s", conn.RemoteAddr())
-				err := conn.WriteJSON(msg)
-
-				if err != nil {
-					log.Error().Err(err).Msg("")
-					delete(b.subscribers, conn.RemoteAddr().String())
-				}
-			}
+			b.broadcast(msg)
 		case conn := <-b.connChan:
 			log.Info().Msgf("Received connection from: %s", conn.RemoteAddr())
-			b.subscribers[conn.RemoteAddr().String()] = conn
-			for name, buildstatus := range b.buildStatus {
-				log.Debug().Msgf("Sending %d messages for builder %s to subscriber %s", len(buildstatus.msgs), name, conn.RemoteAddr())
-				for _, msg := range buildstatus.msgs {
-					log.Trace().Msgf("Sending msg: %T{%s}", msg, msg.Get())
-					conn.WriteJSON(msg)
-				}
-				if buildstatus.state != nil {
-					log.Debug().Msgf("Sending state message for %s", name)
-					conn.WriteJSON(*buildstatus.state)
-				}
-				if buildstatus.error != nil {
-					log.Debug().Msgf("Sending error message for %s", name)
-					conn.WriteJSON(*buildstatus.error)
-				}
+			sub := newSubscriber(conn, b.queueSize)
+			b.subscribers[conn] = sub
+			b.metrics.subscribers.Set(float64(len(b.subscribers)))
+			go sub.run()
+
+			if events, ok := b.missedEvents(conn); !ok {
+				b.send(sub, b.snapshotFrame())
+			} else if len(events) > 0 {
+				b.send(sub, frame{events: events})
 			}
-		case addr := <-b.connCloseCh:
-			log.Info().Msgf("Removing connection: %s", addr)
-			delete(b.subscribers, addr)
+		case req := <-b.snapshotCh:
+			req.reply <- b.buildSnapshot(req.builder)
+		case req := <-b.runsCh:
+			req.reply <- b.buildRuns(req.builder)
+		case reply := <-b.probeCh:
+			reply <- struct{}{}
+		case conn := <-b.connCloseCh:
+			log.Info().Msgf("Removing connection: %s", conn.RemoteAddr())
+			b.removeSubscriber(conn)
+		case <-staleCheck:
+			b.checkStale()
+		case <-stateSave:
+			b.persistState()
 		case <-pingTicker.C:
-			for _, conn := range b.subscribers {
-				if err := conn.WriteComment("ping"); err != nil {
-					log.Error().Err(err).Msg("Removing connection after ping failure")
-					delete(b.subscribers, conn.RemoteAddr().String())
-				}
+			for _, sub := range b.subscribers {
+				b.send(sub, frame{comment: "ping"})
 			}
 		case <-ctx.Done():
 			log.Info().Msg("Shutting down")
-			for _, conn := range b.subscribers {
-				conn.Close()
+			b.lastEventID++
+			msg := Stamp(NewSystemMessage("server-shutting-down", "Server is shutting down"), b.now(), OriginSystem)
+			event := newEvent(b.lastEventID, msg)
+			event.Retry = shutdownRetry
+			for conn, sub := range b.subscribers {
+				b.send(sub, frame{events: []Event{event}})
+				b.removeSubscriber(conn)
 			}
+			if b.stateFile != "" {
+				b.persistState()
+			}
+			b.stop()
 			return
 		}
 
@@ -202,6 +476,209 @@ func (b *BuildStatusPublisher) PublishBuildStatus(ctx context.Context) {
 	}
 }
 
+// stop closes the connections still waiting to be served by the loop,
+// which is about to exit.
+func (b *BuildStatusPublisher) stop() {
+	close(b.done)
+	b.stopMu.Lock()
+	b.stopped = true
+	b.stopMu.Unlock()
+
+	for {
+		select {
+		case conn := <-b.connChan:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// register hands conn to the publisher loop. It returns false once the loop
+// stopped.
+func (b *BuildStatusPublisher) register(conn Connection) bool {
+	b.stopMu.Lock()
+	defer b.stopMu.Unlock()
+
+	if b.stopped {
+		return false
+	}
+	select {
+	case b.connChan <- conn:
+		return true
+	case <-b.done:
+		return false
+	}
+}
+
+// broadcast sends msg to all subscribers as the next event.
+func (b *BuildStatusPublisher) broadcast(msg Message) {
+	log.Debug().Msgf("%T{%s}", msg, msg.Get())
+	b.lastEventID++
+	event := newEvent(b.lastEventID, msg)
+	b.history.add(event)
+	for _, sub := range b.subscribers {
+		log.Trace().Msgf("Sending message to %s", sub.conn.RemoteAddr())
+		b.send(sub, frame{events: []Event{event}})
+	}
+}
+
+// receivedAt returns when msg was received, falling back to the publisher's
+// clock for messages that were never stamped. Replayed and relayed messages
+// keep the time they were originally received.
+func (b *BuildStatusPublisher) receivedAt(msg Message) time.Time {
+	if m, ok := msg.(interface{ ReceivedAt() time.Time }); ok && !m.ReceivedAt().IsZero() {
+		return m.ReceivedAt()
+	}
+
+	return b.now()
+}
+
+// builderNames returns the names of all builders, sorted. System messages are
+// kept under an empty builder name, which is left out.
+func (b *BuildStatusPublisher) builderNames() []string {
+	return slices.DeleteFunc(slices.Sorted(maps.Keys(b.buildStatus)), func(name string) bool {
+		return name == ""
+	})
+}
+
+// removedMessage announces that the publisher dropped the named builder.
+func (b *BuildStatusPublisher) removedMessage(builder string) Message {
+	return RemovedMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "removed",
+			Builder:  builder,
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+	}
+}
+
+func (b *BuildStatusPublisher) errorHistoryMessage(builder string, errs []BuildErrorMessage) Message {
+	return ErrorHistoryMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "errors",
+			Msg:      fmt.Sprintf("%d recent errors", len(errs)),
+			Builder:  builder,
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+		Errors: slices.Clone(errs),
+	}
+}
+
+// send queues a frame for a subscriber and applies the overflow policy if
+// the subscriber is not keeping up.
+func (b *BuildStatusPublisher) send(sub *subscriber, f frame) {
+	addr := sub.conn.RemoteAddr()
+	if sub.failed.Load() {
+		log.Info().Msgf("Removing connection after write failure: %s", addr)
+		b.removeSubscriber(sub.conn)
+		return
+	}
+
+	if sub.enqueue(f) {
+		return
+	}
+
+	switch b.overflowPolicy {
+	case OverflowResync:
+		b.resyncedSubscribers.Add(1)
+		log.Warn().Msgf("Send queue of %s overflowed, resyncing", addr)
+		sub.reset()
+		sub.enqueue(b.snapshotFrame())
+	default:
+		b.droppedSubscribers.Add(1)
+		log.Warn().Msgf("Send queue of %s overflowed, disconnecting", addr)
+		b.removeSubscriber(sub.conn)
+	}
+}
+
+func (b *BuildStatusPublisher) removeSubscriber(conn Connection) {
+	if sub, ok := b.subscribers[conn]; ok {
+		sub.close()
+		delete(b.subscribers, conn)
+		b.metrics.subscribers.Set(float64(len(b.subscribers)))
+	}
+}
+
+// missedEvents returns the events a reconnecting client missed. It returns
+// false if the client did not ask to resume or the missed events are no
+// longer available, in which case the full state has to be sent instead.
+func (b *BuildStatusPublisher) missedEvents(conn Connection) ([]Event, bool) {
+	rc, ok := conn.(resumableConnection)
+	if !ok {
+		return nil, false
+	}
+	lastEventID, ok := rc.LastEventID()
+	if !ok || lastEventID > b.lastEventID {
+		return nil, false
+	}
+	if lastEventID == b.lastEventID {
+		return nil, true
+	}
+
+	events, ok := b.history.since(lastEventID)
+	if !ok {
+		log.Debug().Msgf("Events after %d no longer available for %s", lastEventID, conn.RemoteAddr())
+		return nil, false
+	}
+
+	log.Debug().Msgf("Resuming %s after event %d with %d events", conn.RemoteAddr(), lastEventID, len(events))
+	return events, true
+}
+
+// snapshotFrame returns the snapshot of all builders, preceded by a comment
+// and a SnapshotMessage telling clients to replace the state they have with
+// it.
+func (b *BuildStatusPublisher) snapshotFrame() frame {
+	start := SnapshotMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "snapshot",
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+	}
+	events := append([]Event{newEvent(b.lastEventID, start)}, b.snapshotEvents()...)
+
+	return frame{comment: SnapshotComment, events: events}
+}
+
+// snapshotEvents returns the events that rebuild the current state of all
+// builders on a new subscriber.
+func (b *BuildStatusPublisher) snapshotEvents() []Event {
+	events := []Event{}
+	for name, buildstatus := range b.buildStatus {
+		log.Debug().Msgf("Sending %d messages for builder %s", len(buildstatus.msgs), name)
+		for _, msg := range buildstatus.msgs {
+			log.Trace().Msgf("Sending msg: %T{%s}", msg, msg.Get())
+			events = append(events, newEvent(b.lastEventID, msg))
+		}
+		if buildstatus.state != nil {
+			log.Debug().Msgf("Sending state message for %s", name)
+			events = append(events, newEvent(b.lastEventID, *buildstatus.state))
+		}
+		if len(buildstatus.errors) > 0 {
+			events = append(events, newEvent(b.lastEventID, b.errorHistoryMessage(name, buildstatus.errors)))
+		}
+		if buildstatus.error != nil {
+			log.Debug().Msgf("Sending error message for %s", name)
+			events = append(events, newEvent(b.lastEventID, *buildstatus.error))
+		}
+		if buildstatus.stale != nil {
+			events = append(events, newEvent(b.lastEventID, *buildstatus.stale))
+		}
+		if buildstatus.restored != nil {
+			events = append(events, newEvent(b.lastEventID, *buildstatus.restored))
+		}
+		if buildstatus.run != nil {
+			events = append(events, newEvent(b.lastEventID, b.runStartedMessage(*buildstatus.run)))
+		}
+	}
+
+	return events
+}
+
 func (b *BuildStatusPublisher) sseHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		flusher, ok := w.(http.Flusher)
@@ -214,6 +691,11 @@ func (b *BuildStatusPublisher) sseHandler() http.HandlerFunc {
 			writer:  w,
 			flusher: flusher,
 			remote:  remoteAddr(r),
+			closed:  make(chan struct{}),
+		}
+		if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			conn.lastEventID = id
+			conn.resume = true
 		}
 
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -225,32 +707,64 @@ func (b *BuildStatusPublisher) sseHandler() http.HandlerFunc {
 		}
 		flusher.Flush()
 
-		b.connChan <- conn
-		<-r.Context().Done()
-		b.connCloseCh <- conn.RemoteAddr().String()
+		if !b.register(conn) {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			select {
+			case b.connCloseCh <- conn:
+			case <-b.done:
+			}
+			// The writer goroutine may still use the response writer until
+			// it closes the connection. A stopped publisher closed all of
+			// them.
+			<-conn.closed
+		case <-conn.closed:
+		}
 	}
 }
 
-func (b *BuildStatusPublisher) ListenHTTP(ctx context.Context) {
-	go b.PublishBuildStatus(ctx)
+func (b *BuildStatusPublisher) ListenHTTP(ctx context.Context, listener net.Listener) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	listener, err := net.Listen("tcp", "0.0.0.0:8080")
-	if err != nil {
-		log.Error().Err(err).Msg("http listener failed")
-		return
-	}
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		b.PublishBuildStatus(ctx)
+	}()
 
 	if err := b.serveHTTP(ctx, listener); err != nil {
 		log.Error().Err(err).Msg("http listener failed")
 	}
+
+	// Wait for the publisher to save its state before returning.
+	cancel()
+	<-published
 }
 
-func (b *BuildStatusPublisher) serveHTTP(ctx context.Context, listener net.Listener) error {
+func (b *BuildStatusPublisher) handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/events", b.sseHandler())
+	mux.HandleFunc("GET /api/builders", b.buildersHandler())
+	mux.HandleFunc("GET /api/builders/{name}", b.builderHandler())
+	mux.HandleFunc("GET /api/builders/{name}/runs", b.builderRunsHandler())
+	mux.HandleFunc("GET /api/runs", b.runsHandler())
+	mux.Handle("GET /metrics", b.metricsHandler())
+	mux.HandleFunc("GET /healthz", b.healthzHandler())
+	mux.HandleFunc("GET /readyz", b.readyzHandler())
+	mux.Handle("/", b.staticHandler())
+	for _, h := range b.extraHandlers {
+		mux.Handle(h.pattern, h.handler)
+	}
+
+	return mux
+}
 
+func (b *BuildStatusPublisher) serveHTTP(ctx context.Context, listener net.Listener) error {
 	server := &http.Server{
-		Handler: mux,
+		Handler: b.handler(),
 	}
 
 	shutdownDone := make(chan struct{})
@@ -275,9 +789,14 @@ func (b *BuildStatusPublisher) serveHTTP(ctx context.Context, listener net.Liste
 	return err
 }
 
+// makeStep lets the publisher loop finish one iteration and waits until the
+// subscribers have written everything queued during it.
 func (b *BuildStatusPublisher) makeStep() {
 	if b.stepChan != nil {
 		b.stepChan <- struct{}{}
+		for _, sub := range b.subscribers {
+			sub.pending.Wait()
+		}
 	}
 }
 
@@ -290,18 +809,27 @@ func (b *BuildStatusPublisher) waitStep() {
 }
 
 type sseConnection struct {
-	writer  http.ResponseWriter
-	flusher http.Flusher
-	remote  net.Addr
+	writer      http.ResponseWriter
+	flusher     http.Flusher
+	remote      net.Addr
+	lastEventID uint64
+	resume      bool
+	closed      chan struct{}
+	closeOnce   sync.Once
 }
 
-func (c *sseConnection) WriteJSON(v any) error {
-	data, err := json.Marshal(v)
+func (c *sseConnection) WriteEvent(e Event) error {
+	data, err := e.encode()
 	if err != nil {
 		return err
 	}
 
-	if _, err := fmt.Fprintf(c.writer, "data: %s\n\n", data); err != nil {
+	if e.Retry > 0 {
+		if _, err := fmt.Fprintf(c.writer, "retry: %d\n", e.Retry.Milliseconds()); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(c.writer, "id: %d\ndata: %s\n\n", e.ID, data); err != nil {
 		return err
 	}
 	c.flusher.Flush()
@@ -316,11 +844,20 @@ func (c *sseConnection) WriteComment(text string) error {
 	return nil
 }
 
+func (c *sseConnection) LastEventID() (uint64, bool) {
+	return c.lastEventID, c.resume
+}
+
 func (c *sseConnection) RemoteAddr() net.Addr {
 	return c.remote
 }
 
 func (c *sseConnection) Close() error {
+	c.closeOnce.Do(func() {
+		if c.closed != nil {
+			close(c.closed)
+		}
+	})
 	return nil
 }
 
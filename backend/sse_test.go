@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"net"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,15 +23,15 @@ func TestSSEConnectionWritesMessageEvent(t *testing.T) {
 
 	msg := MessageFromString("build/BuilderA", "pulling git")
 
-	err := conn.WriteJSON(msg)
+	err := conn.WriteEvent(Event{ID: 42, Msg: msg})
 	require.NoError(t, err)
 
 	var payload map[string]any
 	body := recorder.Body.String()
-	require.Contains(t, body, "data: ")
-	require.Contains(t, body, "\n\n")
+	require.True(t, strings.HasPrefix(body, "id: 42\ndata: "))
+	require.True(t, strings.HasSuffix(body, "\n\n"))
 
-	rawJSON := body[len("data: ") : len(body)-2]
+	rawJSON := strings.TrimSuffix(strings.TrimPrefix(body, "id: 42\ndata: "), "\n\n")
 	require.NoError(t, json.Unmarshal([]byte(rawJSON), &payload))
 
 	assert.Equal(t, "msg", payload["MsgType"])
@@ -37,6 +39,67 @@ func TestSSEConnectionWritesMessageEvent(t *testing.T) {
 	assert.Equal(t, "BuilderA", payload["Builder"])
 }
 
+func TestSSEConnectionWritesRetryHint(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	conn := &sseConnection{
+		writer:  recorder,
+		flusher: recorder,
+	}
+
+	err := conn.WriteEvent(Event{ID: 7, Msg: NewSystemMessage("server-shutting-down", ""), Retry: 5 * time.Second})
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), "retry: 5000\nid: 7\ndata: "))
+}
+
+func TestSSEHandlerReadsLastEventID(t *testing.T) {
+	publisher := NewBuildStatusPublisher(make(chan Message, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	request.Header.Set("Last-Event-ID", "1234")
+
+	go publisher.sseHandler().ServeHTTP(httptest.NewRecorder(), request)
+
+	conn := (<-publisher.connChan).(*sseConnection)
+	cancel()
+
+	id, ok := conn.LastEventID()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1234), id)
+}
+
+func TestSSEHandlerAnnouncesSnapshot(t *testing.T) {
+	msgs := make(chan Message)
+	publisher := NewBuildStatusPublisher(msgs)
+	server := httptest.NewServer(publisher.handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.PublishBuildStatus(ctx)
+	msgs <- MessageFromString("build/BuilderA", "pulling git")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	require.NoError(t, err)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	reader := NewEventReader(response.Body)
+	for _, want := range []string{"connected", "snapshot"} {
+		_, comment, err := reader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, want, comment)
+	}
+	event, _, err := reader.Next()
+	require.NoError(t, err)
+	assert.IsType(t, SnapshotMessage{}, event.Msg, "browsers miss the comment")
+	event, _, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, MessageFromString("build/BuilderA", "pulling git"), event.Msg)
+}
+
 func TestServeHTTPShutsDownOnContextCancel(t *testing.T) {
 	publisher := NewBuildStatusPublisher(make(chan Message, 1))
 
@@ -91,3 +154,28 @@ func (l *blockingListener) Close() error {
 func (l *blockingListener) Addr() net.Addr {
 	return l.addr
 }
+
+func TestSSEHandlerReturnsOnceThePublisherStopped(t *testing.T) {
+	publisher := NewBuildStatusPublisher(make(chan Message))
+	// A connection still queued when the loop exits.
+	queued := make(chan Message, 4)
+	publisher.connChan <- mockSubscriber{sent: queued}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	publisher.PublishBuildStatus(ctx)
+
+	for range queued {
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publisher.handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler still waiting for a stopped publisher")
+	}
+}
@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// staleCheckInterval is how often builders are checked for silence.
+const staleCheckInterval = 10 * time.Second
+
+// WithStaleAfter marks builders as stale once nothing was heard from them
+// for the given duration. Zero disables the detection. Idle builders are
+// waiting for work and never stale.
+func WithStaleAfter(d time.Duration) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.staleAfter = d
+	}
+}
+
+// WithStaleAfterState overrides the threshold of WithStaleAfter for builders
+// reporting state, e.g. to give offline builders more time or, with zero,
+// never mark them stale.
+func WithStaleAfterState(state string, d time.Duration) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		if b.staleAfterState == nil {
+			b.staleAfterState = map[string]time.Duration{}
+		}
+		b.staleAfterState[state] = d
+	}
+}
+
+// ParseStaleThresholds parses per state thresholds written as
+// state=duration, separated by commas.
+func ParseStaleThresholds(s string) (map[string]time.Duration, error) {
+	var thresholds map[string]time.Duration
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		state, value, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(state) == "" {
+			return nil, fmt.Errorf("invalid threshold %q, expected state=duration", item)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %w", item, err)
+		}
+		if thresholds == nil {
+			thresholds = map[string]time.Duration{}
+		}
+		thresholds[strings.TrimSpace(state)] = d
+	}
+
+	return thresholds, nil
+}
+
+func (b *BuildStatusPublisher) detectsStale() bool {
+	if b.staleAfter > 0 {
+		return true
+	}
+	for _, d := range b.staleAfterState {
+		if d > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// staleThreshold returns how long the builder may stay silent, or zero if
+// it is never considered stale.
+func (b *BuildStatusPublisher) staleThreshold(bs *BuildStatus) time.Duration {
+	if len(bs.msgs) > 0 {
+		if _, ok := bs.msgs[len(bs.msgs)-1].(IdleMessage); ok {
+			return 0
+		}
+	}
+
+	if bs.state != nil {
+		if d, ok := b.staleAfterState[bs.state.State]; ok {
+			return d
+		}
+	}
+
+	return b.staleAfter
+}
+
+// heardFrom records that a message arrived for the builder and announces
+// that a stale or restored builder is back before the message is processed.
+func (b *BuildStatusPublisher) heardFrom(msg Message, bs *BuildStatus) {
+	// System messages and the messages derived by another instance say
+	// nothing about the builder being alive.
+	switch msg.(type) {
+	case StaleMessage, RestoredMessage, RunStartedMessage, RunEndedMessage:
+		return
+	}
+	if msg.BuilderName() == "" {
+		return
+	}
+
+	bs.lastSeen = b.receivedAt(msg)
+	if bs.restored != nil {
+		saved := bs.restored.Saved
+		bs.restored = nil
+		log.Info().Msgf("Restored state of %s is confirmed", msg.BuilderName())
+		b.broadcast(b.restoredMessage(msg.BuilderName(), saved, false))
+	}
+	if bs.stale != nil {
+		bs.stale = nil
+		log.Info().Msgf("Builder %s is no longer stale", msg.BuilderName())
+		b.broadcast(b.staleMessage(msg.BuilderName(), bs.lastSeen, false))
+	}
+}
+
+// checkStale marks the builders that have been silent for too long.
+func (b *BuildStatusPublisher) checkStale() {
+	now := b.now()
+	for _, name := range b.builderNames() {
+		bs := b.buildStatus[name]
+		if bs.stale != nil || bs.lastSeen.IsZero() {
+			continue
+		}
+
+		threshold := b.staleThreshold(bs)
+		if threshold <= 0 || now.Sub(bs.lastSeen) < threshold {
+			continue
+		}
+
+		log.Info().Msgf("Builder %s is stale, last seen %s ago", name, now.Sub(bs.lastSeen).Round(time.Second))
+		msg := b.staleMessage(name, bs.lastSeen, true)
+		bs.stale = &msg
+		b.broadcast(msg)
+	}
+}
+
+func (b *BuildStatusPublisher) staleMessage(builder string, lastSeen time.Time, stale bool) StaleMessage {
+	text := "Reporting again"
+	if stale {
+		text = fmt.Sprintf("Silent since %s", lastSeen.UTC().Format(time.RFC3339))
+	}
+
+	return StaleMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "stale",
+			Msg:      text,
+			Builder:  builder,
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+		Stale:    stale,
+		LastSeen: lastSeen,
+	}
+}
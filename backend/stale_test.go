@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staleTestPublisher returns a publisher with a clock the test advances and
+// a channel triggering the stale check.
+func staleTestPublisher(t *testing.T, opts ...PublisherOption) (*BuildStatusPublisher, *publisherChannels, chan time.Time, *time.Time, func()) {
+	t.Helper()
+
+	now := testNow
+	check := make(chan time.Time)
+	opts = append(opts, WithClock(func() time.Time { return now }), func(b *BuildStatusPublisher) {
+		b.staleCheck = check
+	})
+	publisher, channels, cancel := createPublisher(t, opts...)
+
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	return publisher, channels, check, &now, cancel
+}
+
+func TestPublisherMarksSilentBuilderStale(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, check, now, cancel := staleTestPublisher(t, WithStaleAfter(10*time.Minute))
+	defer cancel()
+
+	channels.msg <- MessageFromString("build/BuilderA", "1/2 3/10 busybox 1.36.1-r0")
+	publisher.makeStep()
+	drainMessages(channels.sent)
+
+	*now = now.Add(9 * time.Minute)
+	check <- *now
+	publisher.makeStep()
+	require.Empty(drainMessages(channels.sent), "not silent long enough")
+
+	*now = now.Add(time.Minute)
+	check <- *now
+	publisher.makeStep()
+	msgs := drainMessages(channels.sent)
+	require.Len(msgs, 1)
+	assert.Equal(StaleMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "stale",
+			Msg:      "Silent since 2025-03-14T09:26:53Z",
+			Builder:  "BuilderA",
+			Received: testNow.Add(10 * time.Minute),
+			Origin:   OriginSystem,
+		},
+		Stale:    true,
+		LastSeen: testNow,
+	}, msgs[0])
+
+	check <- *now
+	publisher.makeStep()
+	require.Empty(drainMessages(channels.sent), "stale is only announced once")
+
+	snapshot := publisher.buildSnapshot("")
+	require.Len(snapshot, 1)
+	assert.True(snapshot[0].Stale)
+	assert.Equal(testNow, snapshot[0].LastSeen)
+}
+
+func TestPublisherMarksBuilderSeenWhenMessageWasReceived(t *testing.T) {
+	publisher, channels, check, now, cancel := staleTestPublisher(t, WithStaleAfter(10*time.Minute))
+	defer cancel()
+
+	// Relayed from an instance which received it a while ago.
+	received := testNow.Add(-10 * time.Minute)
+	channels.msg <- Stamp(MessageFromString("build/BuilderA", "pulling git"), received, OriginMQTT)
+	publisher.makeStep()
+	drainMessages(channels.sent)
+	assert.Equal(t, received, publisher.buildSnapshot("BuilderA")[0].LastSeen)
+
+	check <- *now
+	publisher.makeStep()
+	msgs := drainMessages(channels.sent)
+	require.Len(t, msgs, 1)
+	assert.True(t, msgs[0].(StaleMessage).Stale)
+}
+
+func TestPublisherClearsStaleWhenBuilderReportsAgain(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	publisher, channels, check, now, cancel := staleTestPublisher(t, WithStaleAfter(time.Minute))
+	defer cancel()
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+	*now = now.Add(time.Hour)
+	check <- *now
+	publisher.makeStep()
+	drainMessages(channels.sent)
+
+	// Even a repeated message shows the builder is alive.
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+
+	msgs := drainMessages(channels.sent)
+	require.Len(msgs, 1)
+	require.IsType(StaleMessage{}, msgs[0])
+	assert.False(msgs[0].(StaleMessage).Stale)
+	assert.Equal(*now, msgs[0].(StaleMessage).LastSeen)
+	assert.False(publisher.buildSnapshot("")[0].Stale)
+}
+
+func TestPublisherSendsStaleStateToNewSubscribers(t *testing.T) {
+	publisher, channels, check, now, cancel := staleTestPublisher(t, WithStaleAfter(time.Minute))
+	defer cancel()
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+	*now = now.Add(time.Hour)
+	check <- *now
+	publisher.makeStep()
+
+	sent := make(chan Message, 32)
+	publisher.connChan <- mockSubscriber{sent: sent}
+	publisher.makeStep()
+
+	msgs := drainMessages(sent)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "pulling git", msgs[0].(GenericMessage).Msg)
+	assert.True(t, msgs[1].(StaleMessage).Stale)
+}
+
+func TestPublisherStaleThresholdPerState(t *testing.T) {
+	publisher, channels, check, now, cancel := staleTestPublisher(t,
+		WithStaleAfter(time.Minute),
+		WithStaleAfterState("offline", 0),
+		WithStaleAfterState("online", time.Hour),
+	)
+	defer cancel()
+
+	for _, m := range [][2]string{
+		{"build/BuilderA/state", "offline"},
+		{"build/BuilderB/state", "online"},
+		{"build/BuilderC", "pulling git"},
+		{"build/BuilderD", "idle"},
+		{"build/BuilderE/state", "online"},
+	} {
+		channels.msg <- MessageFromString(m[0], m[1])
+		publisher.makeStep()
+	}
+	drainMessages(channels.sent)
+
+	*now = now.Add(30 * time.Minute)
+	check <- *now
+	publisher.makeStep()
+	*now = now.Add(30 * time.Minute)
+	channels.msg <- MessageFromString("build/BuilderE", "pulling git")
+	publisher.makeStep()
+	check <- *now
+	publisher.makeStep()
+
+	var stale []string
+	for _, msg := range drainMessages(channels.sent) {
+		if m, ok := msg.(StaleMessage); ok && m.Stale {
+			stale = append(stale, m.Builder)
+		}
+	}
+	assert.Equal(t, []string{"BuilderC", "BuilderB"}, stale)
+}
+
+func TestPublisherWithoutStaleThresholdNeverChecks(t *testing.T) {
+	publisher := NewBuildStatusPublisher(make(chan Message))
+
+	assert.False(t, publisher.detectsStale())
+	assert.True(t, NewBuildStatusPublisher(nil, WithStaleAfterState("online", time.Hour)).detectsStale())
+}
+
+func TestParseStaleThresholds(t *testing.T) {
+	thresholds, err := ParseStaleThresholds("online=30m, offline=0s")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"online": 30 * time.Minute, "offline": 0}, thresholds)
+
+	thresholds, err = ParseStaleThresholds("")
+	require.NoError(t, err)
+	assert.Nil(t, thresholds)
+
+	for _, s := range []string{"online", "=1m", "online=soon"} {
+		_, err := ParseStaleThresholds(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stateFileVersion is increased on incompatible changes to the format of the
+// state file. Files of other versions are not restored.
+const stateFileVersion = 1
+
+const defaultStateSaveInterval = time.Minute
+
+// WithStateFile saves the state of all builders to path periodically and on
+// shutdown, and restores it on startup. Restored builders are flagged until
+// new messages arrive for them.
+func WithStateFile(path string) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.stateFile = path
+	}
+}
+
+// WithStateSaveInterval sets how often the state file is written.
+func WithStateSaveInterval(d time.Duration) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.stateSaveInterval = d
+	}
+}
+
+// savedState is the content of the state file.
+type savedState struct {
+	Version  int
+	Saved    time.Time
+	Builders []savedBuilder
+}
+
+type savedBuilder struct {
+	Builder string
+	// Msgs are stored encoded as they hold different message types.
+	Msgs     []json.RawMessage
+	State    *BuildStateMessage  `json:",omitempty"`
+	Error    *BuildErrorMessage  `json:",omitempty"`
+	Errors   []BuildErrorMessage `json:",omitempty"`
+	Progress *BuildStatusMessage `json:",omitempty"`
+	LastSeen time.Time           `json:",omitzero"`
+	Stale    *StaleMessage       `json:",omitempty"`
+	Run      *BuildRun           `json:",omitempty"`
+	Runs     []BuildRun          `json:",omitempty"`
+}
+
+// persistState saves the state file, logging failures. The publisher keeps
+// running without it.
+func (b *BuildStatusPublisher) persistState() {
+	if err := b.saveState(); err != nil {
+		log.Error().Err(err).Msgf("Failed to save state to %s", b.stateFile)
+	}
+}
+
+func (b *BuildStatusPublisher) saveState() error {
+	state := savedState{
+		Version:  stateFileVersion,
+		Saved:    b.now(),
+		Builders: []savedBuilder{},
+	}
+
+	for _, name := range b.builderNames() {
+		bs := b.buildStatus[name]
+
+		saved := savedBuilder{
+			Builder:  name,
+			State:    bs.state,
+			Errors:   bs.errors,
+			Progress: bs.progress,
+			LastSeen: bs.lastSeen,
+			Stale:    bs.stale,
+			Run:      bs.run,
+			Runs:     bs.runs,
+		}
+		for _, msg := range bs.msgs {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return fmt.Errorf("error encoding state of %s: %w", name, err)
+			}
+			saved.Msgs = append(saved.Msgs, data)
+		}
+		if bs.error != nil {
+			if m, ok := (*bs.error).(BuildErrorMessage); ok {
+				saved.Error = &m
+			}
+		}
+		state.Builders = append(state.Builders, saved)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+
+	return writeFileAtomic(b.stateFile, data)
+}
+
+// writeFileAtomic replaces path with data, so a crash while writing never
+// leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+
+	return nil
+}
+
+// restoreState loads the builders from the state file. A missing file is not
+// an error, the publisher then starts without any builders.
+func (b *BuildStatusPublisher) restoreState() error {
+	data, err := os.ReadFile(b.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Info().Msgf("No state to restore from %s", b.stateFile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state file: %w", err)
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("error decoding state file: %w", err)
+	}
+	if state.Version != stateFileVersion {
+		return fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+
+	// Nothing is restored unless the whole file can be decoded.
+	builders := map[string]*BuildStatus{}
+	for _, saved := range state.Builders {
+		bs := &BuildStatus{
+			maxMsgLen: b.maxMsgLen,
+			maxErrors: b.maxErrors,
+			maxRuns:   b.maxRuns,
+			state:     saved.State,
+			progress:  saved.Progress,
+			lastSeen:  saved.LastSeen,
+			stale:     saved.Stale,
+			run:       saved.Run,
+			runs:      saved.Runs,
+		}
+		for _, data := range saved.Msgs {
+			msg, err := UnmarshalMessage(data)
+			if err != nil {
+				return fmt.Errorf("error decoding state of %s: %w", saved.Builder, err)
+			}
+			bs.addMsg(msg)
+		}
+		if saved.Error != nil {
+			var msg Message = *saved.Error
+			bs.error = &msg
+		}
+		for _, err := range saved.Errors {
+			bs.errors = AppendError(bs.errors, err, bs.maxErrors)
+		}
+		if bs.run != nil && bs.progress != nil {
+			bs.run.progress = bs.progress.TotalProgress.Current
+		}
+		if len(bs.runs) > bs.maxRuns {
+			bs.runs = bs.runs[len(bs.runs)-bs.maxRuns:]
+		}
+
+		if saved.Builder == "" || bs.isEmpty() {
+			continue
+		}
+		restored := b.restoredMessage(saved.Builder, state.Saved, true)
+		bs.restored = &restored
+		builders[saved.Builder] = bs
+	}
+
+	maps.Copy(b.buildStatus, builders)
+	log.Info().Msgf("Restored %d builders from state saved at %s", len(builders), state.Saved.Format(time.RFC3339))
+
+	return nil
+}
+
+func (b *BuildStatusPublisher) restoredMessage(builder string, saved time.Time, restored bool) RestoredMessage {
+	text := "Confirmed by new messages"
+	if restored {
+		text = fmt.Sprintf("Restored from state saved at %s", saved.UTC().Format(time.RFC3339))
+	}
+
+	return RestoredMessage{
+		GenericMessage: GenericMessage{
+			MsgType:  "restored",
+			Msg:      text,
+			Builder:  builder,
+			Received: b.now(),
+			Origin:   OriginSystem,
+		},
+		Restored: restored,
+		Saved:    saved,
+	}
+}
@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runUntilShutdown feeds msgs to a publisher saving its state to path and
+// waits for it to shut down.
+func runUntilShutdown(t *testing.T, path string, msgs ...Message) {
+	t.Helper()
+
+	msgChan := make(chan Message)
+	publisher := NewBuildStatusPublisher(msgChan, WithStateFile(path), WithClock(func() time.Time { return testNow }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publisher.PublishBuildStatus(ctx)
+	}()
+
+	for _, msg := range msgs {
+		msgChan <- msg
+	}
+	// The loop has processed the last message once it takes the next one.
+	msgChan <- NewSystemMessage("mqtt-connected", "Connected to broker")
+	cancel()
+	<-done
+}
+
+func TestPublisherRestoresStateSavedOnShutdown(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	runUntilShutdown(t, path,
+		MessageFromString("build/BuilderA/state", "online"),
+		MessageFromString("build/BuilderA", "pulling git"),
+		MessageFromString("build/BuilderA", "1/2 3/10 main/busybox 1.36.1-r0"),
+		MessageFromString("build/BuilderA/errors", `{"reponame":"main","pkgname":"busybox","logurl":"https://build.alpinelinux.org/log"}`),
+		MessageFromString("build/BuilderB", "idle"),
+	)
+
+	publisher, channels, cancel := createPublisher(t, WithStateFile(path))
+	defer cancel()
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+
+	snapshot := publisher.buildSnapshot("")
+	require.Len(snapshot, 2)
+	assert.Equal("BuilderA", snapshot[0].Builder)
+	assert.Equal("online", snapshot[0].State)
+	assert.Equal([]Message{
+		MessageFromString("build/BuilderA", "pulling git"),
+		MessageFromString("build/BuilderA", "1/2 3/10 main/busybox 1.36.1-r0"),
+	}, snapshot[0].Msgs)
+	require.NotNil(snapshot[0].LastProgress)
+	assert.Equal("main/busybox", snapshot[0].LastProgress.PackageName)
+	assert.Equal("busybox", snapshot[0].Error.(BuildErrorMessage).Pkgname)
+	require.Len(snapshot[0].Errors, 1)
+	assert.Equal(testNow, snapshot[0].LastSeen)
+	assert.True(snapshot[0].Restored)
+	assert.Equal("BuilderB", snapshot[1].Builder)
+	assert.IsType(IdleMessage{}, snapshot[1].Msgs[0])
+
+	runs := publisher.buildRuns("BuilderA")
+	require.NotNil(runs[0].Current, "the run in progress is restored")
+	assert.Equal("main/busybox", runs[0].Current.Packages[0].Name)
+	assert.Len(runs[0].Current.Errors, 1)
+
+	var restored []RestoredMessage
+	for _, msg := range drainMessages(channels.sent) {
+		if m, ok := msg.(RestoredMessage); ok {
+			restored = append(restored, m)
+		}
+	}
+	require.Len(restored, 2, "new subscribers learn which builders are restored")
+	assert.True(restored[0].Restored)
+	assert.Equal(testNow, restored[0].Saved)
+	assert.Equal("Restored from state saved at 2025-03-14T09:26:53Z", restored[0].Msg)
+}
+
+func TestPublisherConfirmsRestoredBuilderOnNewMessage(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	runUntilShutdown(t, path, MessageFromString("build/BuilderA", "pulling git"))
+
+	publisher, channels, cancel := createPublisher(t, WithStateFile(path))
+	defer cancel()
+	publisher.connChan <- mockSubscriber{sent: channels.sent}
+	publisher.makeStep()
+	drainMessages(channels.sent)
+
+	channels.msg <- MessageFromString("build/BuilderA", "upgrading system")
+	publisher.makeStep()
+
+	msgs := drainMessages(channels.sent)
+	require.Len(msgs, 2)
+	require.IsType(RestoredMessage{}, msgs[0])
+	assert.False(msgs[0].(RestoredMessage).Restored)
+	assert.Equal("upgrading system", msgs[1].(GenericMessage).Msg)
+	assert.False(publisher.buildSnapshot("BuilderA")[0].Restored)
+}
+
+func TestPublisherSavesStateForRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	publisher, channels, cancel := createPublisher(t, WithStateFile(path))
+	defer cancel()
+
+	channels.msg <- MessageFromString("build/BuilderA", "pulling git")
+	publisher.makeStep()
+	require.NoError(t, publisher.saveState())
+
+	restored := NewBuildStatusPublisher(nil, WithStateFile(path))
+	require.NoError(t, restored.restoreState())
+	snapshot := restored.buildSnapshot("")
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, "BuilderA", snapshot[0].Builder)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+}
+
+func TestPublisherRestoreIgnoresMissingFile(t *testing.T) {
+	publisher := NewBuildStatusPublisher(nil, WithStateFile(filepath.Join(t.TempDir(), "state.json")))
+
+	require.NoError(t, publisher.restoreState())
+	assert.Empty(t, publisher.buildStatus)
+}
+
+func TestPublisherRestoreRejectsInvalidFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"truncated": `{"Version":1,"Builders":[`,
+		"version":   `{"Version":2,"Builders":[]}`,
+		"message":   `{"Version":1,"Builders":[{"Builder":"BuilderA","Msgs":[{"MsgType":"msg","Msg":"pulling git","Builder":"BuilderA"}]},{"Builder":"BuilderB","Msgs":[{"MsgType":"bogus"}]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			publisher := NewBuildStatusPublisher(nil, WithStateFile(path))
+
+			assert.Error(t, publisher.restoreState())
+			assert.Empty(t, publisher.buildStatus, "nothing is restored from a broken file")
+		})
+	}
+}
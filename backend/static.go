@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFS embed.FS
+
+// WithStaticFS serves the frontend from fsys instead of the assets embedded
+// in the binary, for example from a directory on disk during development.
+func WithStaticFS(fsys fs.FS) PublisherOption {
+	return func(b *BuildStatusPublisher) {
+		b.staticFS = fsys
+	}
+}
+
+func embeddedStaticFS() fs.FS {
+	fsys, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err)
+	}
+
+	return fsys
+}
+
+func (b *BuildStatusPublisher) staticHandler() http.Handler {
+	return http.FileServerFS(b.staticFS)
+}
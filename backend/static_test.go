@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServesEmbeddedFrontend(t *testing.T) {
+	publisher := NewBuildStatusPublisher(make(chan Message, 1))
+
+	for path, content := range map[string]string{
+		"/":                          "Alpine Linux build status",
+		"/js/build-server-status.js": "class BuildServerStatus",
+		"/css/style.css":             "",
+	} {
+		recorder := httptest.NewRecorder()
+		publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+		assert.Contains(t, recorder.Body.String(), content, path)
+	}
+}
+
+func TestServesFrontendFromStaticFS(t *testing.T) {
+	publisher := NewBuildStatusPublisher(make(chan Message, 1), WithStaticFS(fstest.MapFS{
+		"index.html": {Data: []byte("development build")},
+	}))
+
+	recorder := httptest.NewRecorder()
+	publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "development build", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	publisher.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/js/build-server-status.js", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
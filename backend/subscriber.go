@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultQueueSize = 64
+
+// OverflowPolicy decides what happens to a subscriber whose send queue is
+// full because it cannot keep up with the publisher.
+type OverflowPolicy int
+
+const (
+	// OverflowDisconnect drops the subscriber. Clients are expected to
+	// reconnect and resume with Last-Event-ID.
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowResync discards everything queued for the subscriber and
+	// replaces it with a fresh snapshot of the current state.
+	OverflowResync
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "disconnect":
+		return OverflowDisconnect, nil
+	case "resync":
+		return OverflowResync, nil
+	}
+
+	return 0, fmt.Errorf("unknown overflow policy: %s", s)
+}
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDisconnect:
+		return "disconnect"
+	case OverflowResync:
+		return "resync"
+	}
+
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// frame is a unit of work for a subscriber's writer goroutine: a comment,
+// a batch of events, or a comment followed by the events it introduces.
+type frame struct {
+	events  []Event
+	comment string
+}
+
+// subscriber owns the outbound queue of a single connection. The publisher
+// enqueues frames without blocking while a dedicated goroutine writes them
+// to the connection.
+type subscriber struct {
+	conn    Connection
+	queue   chan frame
+	pending sync.WaitGroup
+	failed  atomic.Bool
+	closed  bool
+}
+
+func newSubscriber(conn Connection, queueSize int) *subscriber {
+	return &subscriber{
+		conn:  conn,
+		queue: make(chan frame, queueSize),
+	}
+}
+
+// run writes queued frames to the connection until the queue is closed.
+// After a write fails the remaining frames are discarded.
+func (s *subscriber) run() {
+	defer s.conn.Close()
+
+	for f := range s.queue {
+		if !s.failed.Load() {
+			if err := s.write(f); err != nil {
+				log.Error().Err(err).Msgf("Failed writing to %s", s.conn.RemoteAddr())
+				s.failed.Store(true)
+			}
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *subscriber) write(f frame) error {
+	if f.comment != "" {
+		if err := s.conn.WriteComment(f.comment); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range f.events {
+		if err := s.conn.WriteEvent(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueue adds a frame to the queue and returns false if the queue is full.
+func (s *subscriber) enqueue(f frame) bool {
+	s.pending.Add(1)
+	select {
+	case s.queue <- f:
+		return true
+	default:
+		s.pending.Done()
+		return false
+	}
+}
+
+// reset discards all frames that have not been picked up by the writer yet.
+func (s *subscriber) reset() {
+	for {
+		select {
+		case <-s.queue:
+			s.pending.Done()
+		default:
+			return
+		}
+	}
+}
+
+// close stops the writer once all queued frames have been written.
+func (s *subscriber) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+}
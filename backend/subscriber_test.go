@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDisconnect, OverflowResync} {
+		parsed, err := ParseOverflowPolicy(policy.String())
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseOverflowPolicy("block")
+	assert.Error(t, err)
+}
+
+func TestSlowSubscriberIsDisconnectedWithoutBlockingOthers(t *testing.T) {
+	require := require.New(t)
+
+	msgs := make(chan Message, 1)
+	publisher, cancel := startPublisher(t, msgs, WithQueueSize(1), WithOverflowPolicy(OverflowDisconnect))
+	defer cancel()
+
+	slow := newSlowSubscriber("192.0.2.1:12345")
+	defer close(slow.release)
+	fast := newSlowSubscriber("192.0.2.2:12345")
+	close(fast.release)
+
+	publisher.connChan <- slow
+	publisher.connChan <- fast
+	// Both are registered once their initial snapshot left the queue.
+	require.Equal(SnapshotComment, <-slow.comments)
+	require.Equal(SnapshotComment, <-fast.comments)
+
+	msgs <- MessageFromString("build/BuilderA", "pulling git")
+	<-slow.started
+	// The fast subscriber shares the queue size, so it has to keep up.
+	for _, payload := range []string{"upgrading system", "uploading packages to community"} {
+		<-fast.sent
+		msgs <- MessageFromString("build/BuilderA", payload)
+	}
+
+	require.Eventually(func() bool {
+		return publisher.DroppedSubscribers() == 1
+	}, 5*time.Second, time.Millisecond)
+	<-fast.sent
+	require.Equal(uint64(1), publisher.DroppedSubscribers(), "the fast subscriber is kept")
+	require.Zero(publisher.ResyncedSubscribers())
+}
+
+func TestSlowSubscriberIsResyncedWithSnapshot(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	msgs := make(chan Message, 1)
+	publisher, cancel := startPublisher(t, msgs, WithQueueSize(2), WithOverflowPolicy(OverflowResync))
+	defer cancel()
+
+	slow := newSlowSubscriber("192.0.2.1:12345")
+	publisher.connChan <- slow
+
+	sent := []Message{
+		MessageFromString("build/BuilderA", "pulling git"),
+		MessageFromString("build/BuilderA", "upgrading system"),
+		MessageFromString("build/BuilderA", "uploading packages to community"),
+		// Not a progress message, which would start a run and overflow
+		// the queue with the event announcing it.
+		MessageFromString("build/BuilderA", "uploading packages to main"),
+	}
+
+	msgs <- sent[0]
+	<-slow.started
+	for _, msg := range sent[1:] {
+		msgs <- msg
+	}
+
+	require.Eventually(func() bool {
+		return publisher.ResyncedSubscribers() == 1
+	}, time.Second, time.Millisecond)
+	close(slow.release)
+
+	var received []Message
+	require.Eventually(func() bool {
+		received = append(received, drainMessages(slow.sent)...)
+		return len(received) == 4
+	}, time.Second, time.Millisecond)
+	assert.Equal(sent, received)
+	assert.Zero(publisher.DroppedSubscribers())
+}
+
+func startPublisher(t *testing.T, msgs chan Message, opts ...PublisherOption) (*BuildStatusPublisher, context.CancelFunc) {
+	t.Helper()
+
+	zerolog.SetGlobalLevel(zerolog.FatalLevel)
+	publisher := NewBuildStatusPublisher(msgs, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go publisher.PublishBuildStatus(ctx)
+
+	return publisher, cancel
+}
+
+// slowSubscriber blocks every write until release is closed. Snapshots are
+// told by the SnapshotComment in comments, SnapshotMessages are skipped.
+type slowSubscriber struct {
+	addr     net.Addr
+	started  chan struct{}
+	release  chan struct{}
+	sent     chan Message
+	comments chan string
+}
+
+func newSlowSubscriber(addr string) *slowSubscriber {
+	return &slowSubscriber{
+		addr:     net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr)),
+		started:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+		sent:     make(chan Message, 32),
+		comments: make(chan string, 32),
+	}
+}
+
+func (c *slowSubscriber) WriteEvent(e Event) error {
+	if _, ok := e.Msg.(SnapshotMessage); ok {
+		return nil
+	}
+	select {
+	case c.started <- struct{}{}:
+	default:
+	}
+	<-c.release
+	c.sent <- e.Msg
+	return nil
+}
+
+func (c *slowSubscriber) WriteComment(text string) error {
+	c.comments <- text
+	return nil
+}
+
+func (c *slowSubscriber) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *slowSubscriber) Close() error {
+	return nil
+}
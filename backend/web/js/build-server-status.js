@@ -6,6 +6,7 @@
 const rowTemplate = document.getElementById('template-table-row');
 const buildlogsUri = "https://build.alpinelinux.org/buildlogs";
 const maxActivityCount = 3;
+const maxErrorCount = 10;
 
 class BuildServerStatus {
     constructor() {
@@ -73,6 +74,27 @@ class BuildServerStatus {
         case 'mqtt-disconnected':
             this.status("Broker disconnected", "red");
             break;
+        case 'relay-connected':
+            this.status("Live", "green");
+            break;
+        case 'relay-disconnected':
+            this.status("Upstream disconnected", "red");
+            break;
+        case 'replay-started':
+            this.status("Replaying", "green");
+            break;
+        case 'replay-finished':
+            this.status("Replay finished", "#666");
+            break;
+        case 'simulator-started':
+            this.status("Simulating", "green");
+            break;
+        case 'simulator-finished':
+            this.status("Simulation stopped", "#666");
+            break;
+        case 'server-shutting-down':
+            this.status("Server restarting", "#666");
+            break;
         default:
             this.status("Connecting", "#666");
             break;
@@ -91,6 +113,11 @@ class BuildServerInterface {
         if (msg.MsgType === 'system') {
             return;
         }
+        if (msg.MsgType === 'snapshot') {
+            // The events that follow replace the builders we have.
+            this.clear();
+            return;
+        }
         if (msg.MsgType === 'removed') {
             this.removeBuilder(msg.Builder);
             return;
@@ -119,6 +146,14 @@ class BuildServerInterface {
         this.sortTable();
     }
 
+    clear() {
+        for (const builder of Object.values(this.builders)) {
+            builder.remove();
+        }
+        this.builders = {};
+        this.builderNr = 1;
+    }
+
     sortTable() {
         const collator = new Intl.Collator([], {numeric: true});
 
@@ -159,6 +194,9 @@ class Builder {
         this.builderName = builderName;
         this.activity = [];
         this.state = null;
+        this.stale = false;
+        this.restored = null;
+        this.errors = [];
 
         this.elem = rowTemplate.content.firstElementChild.cloneNode(true);
         this.elem.getElementsByClassName('nr')[0].innerText = nr;
@@ -178,6 +216,18 @@ class Builder {
             this.state = msg.State;
             this.renderHost();
             break;
+        case "stale":
+            this.stale = msg.Stale;
+            this.renderHost();
+            break;
+        case "run-started":
+        case "run-ended":
+            // The progress of the run is shown from its progress messages.
+            break;
+        case "restored":
+            this.restored = msg.Restored ? msg.Msg : null;
+            this.renderHost();
+            break;
         case "progress":
             let pkgname = msg.PackageName.split("/")[1];
             this.activity.push({
@@ -188,7 +238,17 @@ class Builder {
             this.updateProgress('prgr_built', msg.BuildProgress);
             this.updateProgress('prgr_total', msg.TotalProgress);
             break;
+        case "errors":
+            this.errors = msg.Errors;
+            this.renderErrorHistory();
+            break;
         case "error":
+            // The current error is sent again after the history.
+            const last = this.errors[this.errors.length - 1];
+            if (last == undefined || last.Received !== msg.Received || last.Pkgname !== msg.Pkgname) {
+                this.errors = this.errors.concat([msg]).slice(-maxErrorCount);
+            }
+            this.renderErrorHistory();
             this.updateError(msg);
             break;
         case "idle":
@@ -212,12 +272,18 @@ class Builder {
     }
 
     renderHost() {
-        if (this.state == null || this.state === "") {
-            this.hostElem.innerHTML = this.builderName;
-            return;
+        let html = this.builderName;
+        if (this.state != null && this.state !== "") {
+            html += ` <span class="builder-state builder-state-${this.state}">${this.state}</span>`;
+        }
+        if (this.stale) {
+            html += ` <span class="builder-state builder-state-stale">stale</span>`;
+        }
+        if (this.restored != null) {
+            html += ` <span class="builder-state builder-state-restored" title="${this.restored}">restored</span>`;
         }
 
-        this.hostElem.innerHTML = `${this.builderName} <span class="builder-state builder-state-${this.state}">${this.state}</span>`;
+        this.hostElem.innerHTML = html;
     }
 
     updateActivity(activity) {
@@ -254,6 +320,12 @@ class Builder {
         }
     }
 
+    renderErrorHistory() {
+        this.elem.getElementsByClassName('errmsgs')[0].title = this.errors
+            .map(e => `${e.Received ?? ""} ${e.Reponame}/${e.Pkgname}`.trim())
+            .join("\n");
+    }
+
     updateError(err) {
         const errElem = this.elem.getElementsByClassName('errmsgs')[0];
 